@@ -1,5 +1,10 @@
+import json
+import threading
+from concurrent import futures
+
+import grpc
 from bs4 import BeautifulSoup
-from flask import Flask, request
+from flask import Flask, jsonify, request
 from sklearn.feature_extraction.text import TfidfVectorizer
 from transformers import pipeline
 
@@ -10,10 +15,9 @@ summarizer = pipeline('summarization', model='facebook/bart-large-cnn')
 # Summarization
 @app.route('/summarize', methods=['POST'])
 def summarize():
-    html = request.data.decode('utf-8')
-    if html == '':
-        return 'No html provided'
-    text = get_text_from_html(html)
+    text = get_text_from_request()
+    if text == '':
+        return 'No text provided'
 
     return summarize_text(text)
 
@@ -28,8 +32,7 @@ def summarize_text(text: str) -> str:
 # Keywords
 @app.route('/keywords', methods=['POST'])
 def keywords():
-    html = request.data.decode('utf-8')
-    text = get_text_from_html(html)
+    text = get_text_from_request()
     return get_keywords(text)
 
 
@@ -42,10 +45,132 @@ def get_keywords(text: str, num_keywords: int = 10) -> list:
     return vectorizer.get_feature_names_out().tolist()
 
 
+# Protocol, see docs/analyzer.md
+PROTOCOL_VERSION = 'v1'
+ANALYZER_VERSION = 'bart-large-cnn+tfidf-1'
+TASKS = ['summary', 'keywords']
+LANGUAGES = ['en']
+MAX_BATCH_SIZE = 16
+GRPC_PORT = 9899
+
+
+def get_capabilities() -> dict:
+    return {
+        'version': PROTOCOL_VERSION,
+        'name': 'flask',
+        'analyzer_version': ANALYZER_VERSION,
+        'tasks': TASKS,
+        'languages': LANGUAGES,
+        'max_batch_size': MAX_BATCH_SIZE,
+    }
+
+
+def analyze_request(body) -> dict:
+    """Answers an analysis request, a request that fails as a whole gets a top level error"""
+    if not isinstance(body, dict):
+        return protocol_error('bad_request', 'expected a JSON object')
+    if body.get('version') != PROTOCOL_VERSION:
+        return protocol_error('unsupported_version', f'expected version {PROTOCOL_VERSION}')
+    tasks = body.get('tasks') or []
+    for task in tasks:
+        if task not in TASKS:
+            return protocol_error('unsupported_task', f'unknown task {task}')
+    documents = body.get('documents') or []
+    if len(documents) > MAX_BATCH_SIZE:
+        return protocol_error('batch_too_large', f'at most {MAX_BATCH_SIZE} documents')
+
+    return {
+        'version': PROTOCOL_VERSION,
+        'results': [analyze_document(document, tasks) for document in documents],
+    }
+
+
+@app.route(f'/{PROTOCOL_VERSION}/capabilities', methods=['GET'])
+def capabilities():
+    return jsonify(get_capabilities())
+
+
+@app.route(f'/{PROTOCOL_VERSION}/analyze', methods=['POST'])
+def analyze():
+    response = analyze_request(request.get_json(silent=True))
+    return jsonify(response), 400 if 'error' in response else 200
+
+
+def analyze_document(document: dict, tasks: list) -> dict:
+    result = {'id': document.get('id', '')}
+    language = document.get('language', '')
+    if language and language not in LANGUAGES:
+        result['error'] = {'code': 'unsupported_language', 'message': f'{language} is not supported'}
+        return result
+
+    text = document.get('text', '')
+    try:
+        if 'summary' in tasks and text:
+            result['summary'] = summarize_text(text)
+        if 'keywords' in tasks:
+            result['keywords'] = [{'term': term, 'score': score} for term, score in get_scored_keywords(text)]
+    except Exception as e:
+        result = {'id': result['id'], 'error': {'code': 'internal', 'message': str(e)}}
+    return result
+
+
+def get_scored_keywords(text: str, num_keywords: int = 10) -> list:
+    vectorizer = TfidfVectorizer()
+    try:
+        scores = vectorizer.fit_transform([text]).toarray()[0]
+    except ValueError:
+        return []
+    terms = vectorizer.get_feature_names_out()
+    ranked = sorted(zip(terms, scores), key=lambda pair: (-pair[1], pair[0]))
+    return [(term, float(score)) for term, score in ranked[:num_keywords]]
+
+
+def protocol_error(code: str, message: str) -> dict:
+    return {'version': PROTOCOL_VERSION, 'results': [], 'error': {'code': code, 'message': message}}
+
+
+# gRPC, the service in v1/analyzer.proto with the same JSON messages as over
+# HTTP, sent with the "json" content subtype like the crawler does
+def decode_json(data: bytes):
+    try:
+        return json.loads(data)
+    except ValueError:
+        return None
+
+
+def encode_json(value: dict) -> bytes:
+    return json.dumps(value).encode('utf-8')
+
+
+def serve_grpc(port: int):
+    handler = grpc.method_handlers_generic_handler(f'analyzer.{PROTOCOL_VERSION}.Analyzer', {
+        'Capabilities': grpc.unary_unary_rpc_method_handler(
+            lambda body, context: get_capabilities(),
+            request_deserializer=decode_json, response_serializer=encode_json),
+        'Analyze': grpc.unary_unary_rpc_method_handler(
+            lambda body, context: analyze_request(body),
+            request_deserializer=decode_json, response_serializer=encode_json),
+    })
+    server = grpc.server(futures.ThreadPoolExecutor(max_workers=4))
+    server.add_generic_rpc_handlers((handler,))
+    server.add_insecure_port(f'localhost:{port}')
+    server.start()
+    server.wait_for_termination()
+
+
 # Utils
+def get_text_from_request() -> str:
+    # The crawler sends the extracted text of a page, raw html is still accepted
+    data = request.data.decode('utf-8')
+    if request.mimetype == 'text/plain':
+        return data
+    return get_text_from_html(data)
+
+
 def get_text_from_html(html: str):
     soup = BeautifulSoup(html, 'html.parser')
     return soup.get_text()
 
 if __name__ == '__main__':
+    threading.Thread(target=serve_grpc, args=(GRPC_PORT,), daemon=True).start()
     app.run(port=9898)
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/dgraph-io/dgo/v2"
+)
+
+// The analysis stage runs the analyzers apart from the spiders, so fetching
+// never waits on model inference. Workers take crawled pages in batches and
+// update the stored page when the results arrive
+
+type Analysis_worker struct {
+	id        int
+	logger    *log.Logger
+	analyzers []Analyzer
+}
+
+// start_analysis starts the analysis workers, they stop once
+// index.pages_to_analyze is closed and drained
+func start_analysis(index *Index, analyzers []Analyzer, dg *dgo.Dgraph) *sync.WaitGroup {
+	var workers sync.WaitGroup
+	for i := 0; i < ANALYSIS_WORKERS; i++ {
+		worker := Analysis_worker{
+			id: i,
+			logger: log.NewWithOptions(os.Stderr, log.Options{
+				ReportTimestamp: true,
+				TimeFormat:      time.Kitchen,
+				Prefix:          "Analyzer " + string(rune('A'+i)),
+			}),
+			analyzers: analyzers,
+		}
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			worker.analyze(index, dg)
+		}()
+	}
+	return &workers
+}
+
+// queue_analysis hands a page to the analysis stage, if the stage is
+// backed up the page waits in the backlog instead of holding up the spider
+func (index *Index) queue_analysis(page *Page) {
+	select {
+	case index.pages_to_analyze <- page:
+	default:
+		index.add_to_analysis_backlog(page)
+	}
+}
+
+func (index *Index) add_to_analysis_backlog(page *Page) {
+	index.lock.Lock()
+	defer index.lock.Unlock()
+	index.analysis_backlog = append(index.analysis_backlog, page)
+}
+
+func (worker *Analysis_worker) analyze(index *Index, dg *dgo.Dgraph) {
+	for {
+		batch, ok := next_batch(index.pages_to_analyze)
+		if len(batch) > 0 {
+			succeeded := run_analyzers(batch, worker.analyzers, worker.logger)
+			for i, page := range batch {
+				apply_annotations(page, worker.analyzers)
+				Db_update_annotations(dg, page, &index.crawl)
+				if !succeeded[i] {
+					index.add_to_analysis_backlog(page)
+				}
+			}
+			worker.logger.Info("analyzed pages", "count", len(batch), "waiting", len(index.pages_to_analyze))
+		}
+		if !ok {
+			return
+		}
+	}
+}
+
+// next_batch waits for a page, then takes up to ANALYSIS_BATCH_SIZE pages
+// that arrive within ANALYSIS_BATCH_WAIT. Returns false once the channel is closed
+func next_batch(pages chan *Page) ([]*Page, bool) {
+	page, ok := <-pages
+	if !ok {
+		return nil, false
+	}
+	batch := []*Page{page}
+
+	timeout := time.After(ANALYSIS_BATCH_WAIT)
+	for len(batch) < ANALYSIS_BATCH_SIZE {
+		select {
+		case page, ok := <-pages:
+			if !ok {
+				return batch, false
+			}
+			batch = append(batch, page)
+		case <-timeout:
+			return batch, true
+		}
+	}
+	return batch, true
+}
+
+// run_analyzers runs the analyzers that have no annotations on the pages
+// yet, batching pages for analyzers that support it. Returns whether every
+// analyzer succeeded, per page
+func run_analyzers(pages []*Page, analyzers []Analyzer, logger *log.Logger) []bool {
+	succeeded := make([]bool, len(pages))
+	for i, page := range pages {
+		succeeded[i] = true
+		if page.annotations == nil {
+			page.annotations = make(map[string]Annotations)
+		}
+	}
+
+	for _, analyzer := range analyzers {
+		pending := []int{}
+		documents := []Document{}
+		for i, page := range pages {
+			if _, done := pages[i].annotations[analyzer.name()]; done {
+				continue
+			}
+			if SKIP_UNSUPPORTED_LANGUAGES && page.Language != "" && !supports_language(analyzer, page.Language) {
+				pages[i].annotations[analyzer.name()] = Annotations{}
+				continue
+			}
+			pending = append(pending, i)
+			documents = append(documents, Document{URL: page.URL, Text: page.Text, Language: page.Language})
+		}
+		if len(pending) == 0 {
+			continue
+		}
+
+		results := make([]Annotations, len(documents))
+		errs := make([]error, len(documents))
+		if batch_analyzer, ok := analyzer.(Batch_analyzer); ok && len(documents) > 1 {
+			results, errs = batch_analyzer.analyze_batch(documents)
+		} else {
+			for j, document := range documents {
+				results[j], errs[j] = analyzer.analyze(document)
+			}
+		}
+
+		for j, i := range pending {
+			if errs[j] != nil {
+				if errs[j] != ERROR_CIRCUIT_OPEN {
+					logger.Warn("analyzer failed", "analyzer", analyzer.name(), "URL", pages[i].URL, "err", errs[j])
+				}
+				succeeded[i] = false
+				continue
+			}
+			pages[i].annotations[analyzer.name()] = results[j]
+		}
+	}
+	return succeeded
+}
+
+// retry_analysis_backlog gives the analyzers that failed during the crawl
+// another go at their pages, and stores what they return
+func retry_analysis_backlog(index *Index, analyzers []Analyzer, dg *dgo.Dgraph) {
+	if len(index.analysis_backlog) == 0 {
+		return
+	}
+	log.Info("Analyzing pages the analyzers failed on", "pages", len(index.analysis_backlog))
+
+	// An analyzer skipped after failing would skip the whole backlog too
+	back := time.Time{}
+	for _, analyzer := range analyzers {
+		if until := resting_until(analyzer); until.After(back) {
+			back = until
+		}
+	}
+	if wait := time.Until(back); wait > 0 {
+		log.Info("Waiting for failing analyzers to cool down", "wait", wait.Round(time.Second))
+		time.Sleep(wait)
+	}
+
+	succeeded := run_analyzers(index.analysis_backlog, analyzers, log.Default())
+	recovered := 0
+	for i, page := range index.analysis_backlog {
+		if succeeded[i] {
+			recovered += 1
+		}
+		apply_annotations(page, analyzers)
+		Db_update_annotations(dg, page, &index.crawl)
+	}
+	log.Info("Analysis backlog done", "recovered", recovered, "still failing", len(index.analysis_backlog)-recovered)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// Analysis_cache stores analyzer results on disk, keyed by a hash of the
+// analyzer's name and version and the text analyzed. Identical pages, within
+// a run or across runs, are only analyzed once
+type Analysis_cache struct {
+	dir    string
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+func new_analysis_cache(dir string) *Analysis_cache {
+	return &Analysis_cache{dir: dir}
+}
+
+func (cache *Analysis_cache) key(analyzer Analyzer, document Document) string {
+	hash := sha256.New()
+	for _, part := range []string{analyzer.name(), analyzer.version(), document.Language, document.Text} {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// path shards entries by the first two characters of the key
+func (cache *Analysis_cache) path(key string) string {
+	return filepath.Join(cache.dir, key[:2], key+".json")
+}
+
+func (cache *Analysis_cache) get(key string) (Annotations, bool) {
+	var annotations Annotations
+	data, err := os.ReadFile(cache.path(key))
+	if err == nil && json.Unmarshal(data, &annotations) == nil {
+		cache.hits.Add(1)
+		return annotations, true
+	}
+	cache.misses.Add(1)
+	return Annotations{}, false
+}
+
+func (cache *Analysis_cache) put(key string, annotations Annotations) {
+	data, err := json.Marshal(annotations)
+	if err != nil {
+		log.Warn("could not cache annotations", "err", err)
+		return
+	}
+	path := cache.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		log.Warn("could not cache annotations", "err", err)
+		return
+	}
+
+	// Write to a file of our own then rename, so a reader never sees half an
+	// entry and writers of the same key don't write over each other
+	tmp, err := os.CreateTemp(filepath.Dir(path), key+".*.tmp")
+	if err != nil {
+		log.Warn("could not cache annotations", "err", err)
+		return
+	}
+	_, err = tmp.Write(data)
+	if close_err := tmp.Close(); err == nil {
+		err = close_err
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		log.Warn("could not cache annotations", "err", err)
+	}
+}
+
+func (cache *Analysis_cache) report() {
+	hits, misses := cache.hits.Load(), cache.misses.Load()
+	hit_rate := 0.0
+	if hits+misses > 0 {
+		hit_rate = float64(hits) / float64(hits+misses) * 100
+	}
+	log.Infof("Analysis cache: %d hits, %d misses (%.1f%% hit rate)", hits, misses, hit_rate)
+}
+
+// Cached_analyzer checks the cache before calling the analyzer it wraps
+type Cached_analyzer struct {
+	analyzer Analyzer
+	cache    *Analysis_cache
+}
+
+func new_cached_analyzer(analyzer Analyzer, cache *Analysis_cache) *Cached_analyzer {
+	return &Cached_analyzer{analyzer: analyzer, cache: cache}
+}
+
+func (cached *Cached_analyzer) name() string {
+	return cached.analyzer.name()
+}
+
+func (cached *Cached_analyzer) version() string {
+	return cached.analyzer.version()
+}
+
+func (cached *Cached_analyzer) supports_language(language string) bool {
+	return supports_language(cached.analyzer, language)
+}
+
+func (cached *Cached_analyzer) resting_until() time.Time {
+	return resting_until(cached.analyzer)
+}
+
+// Results of an analyzer that can't tell its version aren't cached, they
+// could be served again after it changed
+func (cached *Cached_analyzer) analyze(document Document) (Annotations, error) {
+	if cached.version() == "" {
+		return cached.analyzer.analyze(document)
+	}
+	key := cached.cache.key(cached.analyzer, document)
+	if annotations, ok := cached.cache.get(key); ok {
+		return annotations, nil
+	}
+
+	annotations, err := cached.analyzer.analyze(document)
+	if err != nil {
+		return Annotations{}, err
+	}
+	cached.cache.put(key, annotations)
+	return annotations, nil
+}
+
+// analyze_batch only sends the documents missing from the cache on
+func (cached *Cached_analyzer) analyze_batch(documents []Document) ([]Annotations, []error) {
+	if cached.version() == "" {
+		return analyze_all(cached.analyzer, documents)
+	}
+
+	results := make([]Annotations, len(documents))
+	errs := make([]error, len(documents))
+
+	keys := make([]string, len(documents))
+	missing := []int{}
+	missing_documents := []Document{}
+	for i, document := range documents {
+		keys[i] = cached.cache.key(cached.analyzer, document)
+		if annotations, ok := cached.cache.get(keys[i]); ok {
+			results[i] = annotations
+			continue
+		}
+		missing = append(missing, i)
+		missing_documents = append(missing_documents, document)
+	}
+	if len(missing) == 0 {
+		return results, errs
+	}
+
+	missing_results, missing_errs := analyze_all(cached.analyzer, missing_documents)
+
+	for j, i := range missing {
+		results[i], errs[i] = missing_results[j], missing_errs[j]
+		if errs[i] == nil {
+			cached.cache.put(keys[i], results[i])
+		}
+	}
+	return results, errs
+}
+
+// analyze_all analyzes the documents in one batch when the analyzer can
+func analyze_all(analyzer Analyzer, documents []Document) ([]Annotations, []error) {
+	if batch_analyzer, ok := analyzer.(Batch_analyzer); ok {
+		return batch_analyzer.analyze_batch(documents)
+	}
+	results := make([]Annotations, len(documents))
+	errs := make([]error, len(documents))
+	for i, document := range documents {
+		results[i], errs[i] = analyzer.analyze(document)
+	}
+	return results, errs
+}
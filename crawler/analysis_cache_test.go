@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestAnalysisCache(t *testing.T) {
+	cache := new_analysis_cache(t.TempDir())
+	fake := &Fake_analyzer{}
+	cached := new_cached_analyzer(fake, cache)
+	document := Document{URL: "https://a.com/", Text: "some text"}
+
+	for i := 0; i < 2; i++ {
+		annotations, err := cached.analyze(document)
+		if err != nil || annotations.Summary != "summary of https://a.com/" {
+			t.Fatalf("call %d got %+v, %v", i, annotations, err)
+		}
+	}
+	if fake.calls != 1 || cache.hits.Load() != 1 || cache.misses.Load() != 1 {
+		t.Errorf("%d calls, %d hits, %d misses, want the second call served from the cache", fake.calls, cache.hits.Load(), cache.misses.Load())
+	}
+
+	// The same text on another page is a hit, other text isn't
+	cached.analyze(Document{URL: "https://a.com/copy", Text: "some text"})
+	cached.analyze(Document{URL: "https://a.com/other", Text: "other text"})
+	if fake.calls != 2 {
+		t.Errorf("%d calls, want 2", fake.calls)
+	}
+
+	// Failures aren't cached
+	fake.set_failing(true)
+	document = Document{URL: "https://a.com/", Text: "failing text"}
+	cached.analyze(document)
+	fake.set_failing(false)
+	if _, err := cached.analyze(document); err != nil || fake.calls != 4 {
+		t.Errorf("%d calls, %v, want the failure analyzed again", fake.calls, err)
+	}
+}
+
+func TestAnalysisCacheBatch(t *testing.T) {
+	cache := new_analysis_cache(t.TempDir())
+	fake := &Fake_analyzer{}
+	cached := new_cached_analyzer(fake, cache)
+
+	cached.analyze(Document{URL: "https://a.com/1", Text: "one"})
+	results, errs := cached.analyze_batch([]Document{{URL: "https://a.com/1", Text: "one"}, {URL: "https://a.com/2", Text: "two"}})
+	if fake.calls != 2 {
+		t.Errorf("%d calls, want only the missing document analyzed", fake.calls)
+	}
+	for i, result := range results {
+		if errs[i] != nil || result.Summary == "" {
+			t.Errorf("document %d got %+v, %v", i, result, errs[i])
+		}
+	}
+}
+
+func TestAnalysisCacheConcurrentPut(t *testing.T) {
+	cache := new_analysis_cache(t.TempDir())
+	key := cache.key(&Fake_analyzer{}, Document{Text: "text"})
+
+	var wait_group sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wait_group.Add(1)
+		go func(i int) {
+			defer wait_group.Done()
+			cache.put(key, Annotations{Summary: fmt.Sprint("summary ", i)})
+		}(i)
+	}
+	wait_group.Wait()
+
+	if _, ok := cache.get(key); !ok {
+		t.Error("entry unreadable after concurrent writes")
+	}
+	leftovers, _ := filepath.Glob(filepath.Join(filepath.Dir(cache.path(key)), "*.tmp"))
+	if len(leftovers) > 0 {
+		t.Errorf("temporary files left behind: %v", leftovers)
+	}
+	if _, err := os.Stat(cache.path(key)); err != nil {
+		t.Error(err)
+	}
+}
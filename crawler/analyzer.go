@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/dgraph-io/dgo/v2"
+)
+
+// Analyzer backends
+const ANALYZER_HTTP = "http"     // The Flask server in /analyzer, or any service speaking the protocol over HTTP
+const ANALYZER_GRPC = "grpc"     // Any service speaking the protocol over gRPC
+const ANALYZER_NATIVE = "native" // Frequency based summary and keywords, in Go
+const ANALYZER_NOOP = "noop"     // Does nothing, for crawling without analysis
+
+// A Document is what analyzers get to see of a page
+type Document struct {
+	URL  URL
+	Text string
+	// An ISO 639-1 code, empty if unknown
+	Language string
+}
+
+// Annotations are the results of analyzing a document, empty fields are
+// left for other analyzers to fill
+type Annotations struct {
+	Summary  string
+	Keywords []string
+	// Scores by keyword, for analyzers that score them
+	Keyword_scores map[string]float64
+	// Weights of the page's topics, by name, from an analyzer scoring
+	// terms against the whole crawl. Only one analyzer's are kept
+	Topic_scores map[string]float64
+	Entities     []Named_entity
+}
+
+type Analyzer interface {
+	name() string
+	// version changes whenever the analyzer's results would, it's part of
+	// the analysis cache key
+	version() string
+	analyze(document Document) (Annotations, error)
+}
+
+// A Batch_analyzer can analyze several documents in one call, results are
+// in the order of the documents
+type Batch_analyzer interface {
+	Analyzer
+	analyze_batch(documents []Document) ([]Annotations, []error)
+}
+
+// A Language_analyzer only supports some languages, analyzers that aren't
+// one take any language
+type Language_analyzer interface {
+	Analyzer
+	supports_language(language string) bool
+}
+
+// supports_language checks an analyzer, and the analyzer it wraps if any
+func supports_language(analyzer Analyzer, language string) bool {
+	if language_analyzer, ok := analyzer.(Language_analyzer); ok {
+		return language_analyzer.supports_language(language)
+	}
+	return true
+}
+
+// A Resting_analyzer is skipped for a while after failing, until the time
+// it returns
+type Resting_analyzer interface {
+	Analyzer
+	resting_until() time.Time
+}
+
+// resting_until checks an analyzer, and the analyzer it wraps if any
+func resting_until(analyzer Analyzer) time.Time {
+	if resting_analyzer, ok := analyzer.(Resting_analyzer); ok {
+		return resting_analyzer.resting_until()
+	}
+	return time.Time{}
+}
+
+// A Corpus_analyzer learns from every page it analyzes, so its results for
+// early pages improve once the crawl is over
+type Corpus_analyzer interface {
+	Analyzer
+	rescore() map[URL]Annotations
+}
+
+func new_analyzer(name string) (Analyzer, error) {
+	switch name {
+	case ANALYZER_HTTP:
+		return new_breaker_analyzer(new_protocol_analyzer(ANALYZER_HTTP, new_http_transport(ANALYZER_URL), ANALYZER_TASKS)), nil
+	case ANALYZER_GRPC:
+		transport, err := new_grpc_transport(ANALYZER_GRPC_ADDRESS)
+		if err != nil {
+			return nil, err
+		}
+		return new_breaker_analyzer(new_protocol_analyzer(ANALYZER_GRPC, transport, ANALYZER_TASKS)), nil
+	case ANALYZER_NATIVE:
+		return &Native_analyzer{}, nil
+	case ANALYZER_NOOP:
+		return &Noop_analyzer{}, nil
+	case ANALYZER_TFIDF:
+		return new_tfidf_analyzer(), nil
+	case ANALYZER_ENTITIES:
+		return new_entity_analyzer(), nil
+	}
+	return nil, fmt.Errorf("unknown analyzer %q", name)
+}
+
+// new_analyzers creates the analyzers, results of the ones that only look
+// at the page itself are cached when cache isn't nil
+func new_analyzers(names []string, cache *Analysis_cache) ([]Analyzer, error) {
+	analyzers := []Analyzer{}
+	for _, name := range names {
+		analyzer, err := new_analyzer(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := analyzer.(Corpus_analyzer); !ok && cache != nil {
+			analyzer = new_cached_analyzer(analyzer, cache)
+		}
+		analyzers = append(analyzers, analyzer)
+	}
+	return analyzers, nil
+}
+
+// merge fills the fields of annotations that are still empty, keywords
+// and entities from every analyzer are kept
+func (annotations *Annotations) merge(other Annotations) {
+	if annotations.Summary == "" {
+		annotations.Summary = other.Summary
+	}
+	if len(annotations.Topic_scores) == 0 {
+		annotations.Topic_scores = other.Topic_scores
+	}
+
+	seen := make(map[string]bool)
+	for _, keyword := range annotations.Keywords {
+		seen[keyword] = true
+	}
+	for _, keyword := range other.Keywords {
+		if !seen[keyword] {
+			annotations.Keywords = append(annotations.Keywords, keyword)
+			seen[keyword] = true
+			if score, ok := other.Keyword_scores[keyword]; ok {
+				if annotations.Keyword_scores == nil {
+					annotations.Keyword_scores = make(map[string]float64)
+				}
+				annotations.Keyword_scores[keyword] = score
+			}
+		}
+	}
+
+	seen_entities := make(map[string]bool)
+	for _, entity := range annotations.Entities {
+		seen_entities[entity.Key] = true
+	}
+	for _, entity := range other.Entities {
+		if !seen_entities[entity.Key] {
+			annotations.Entities = append(annotations.Entities, entity)
+			seen_entities[entity.Key] = true
+		}
+	}
+}
+
+// apply stores the annotations on the page
+func (annotations *Annotations) apply(page *Page) {
+	page.Summary = annotations.Summary
+	page.Keywords = []*string{}
+	for i := range annotations.Keywords {
+		page.Keywords = append(page.Keywords, &annotations.Keywords[i])
+	}
+	page.Topics = topics_from_annotations(*annotations)
+	page.Mentions = annotations.Entities
+}
+
+// apply_annotations merges the results of each analyzer, in config order,
+// onto the page
+func apply_annotations(page *Page, analyzers []Analyzer) {
+	annotations := Annotations{}
+	for _, analyzer := range analyzers {
+		annotations.merge(page.annotations[analyzer.name()])
+	}
+	annotations.apply(page)
+}
+
+// rescore_pages asks every corpus analyzer for its final results once the
+// crawl is over, and updates the pages that changed
+func rescore_pages(index *Index, analyzers []Analyzer, dg *dgo.Dgraph) {
+	rescored := make(map[URL]*Page)
+	for _, analyzer := range analyzers {
+		corpus_analyzer, ok := analyzer.(Corpus_analyzer)
+		if !ok {
+			continue
+		}
+		for url, annotations := range corpus_analyzer.rescore() {
+			page, ok := index.inprogress_or_done_pages[url]
+			if !ok || page.URL != url || page.annotations == nil {
+				continue
+			}
+			page.annotations[analyzer.name()] = annotations
+			rescored[url] = page
+		}
+	}
+
+	for _, page := range rescored {
+		apply_annotations(page, analyzers)
+		Db_update_annotations(dg, page, &index.crawl)
+	}
+	if len(rescored) > 0 {
+		log.Info("Rescored keywords with the whole crawl", "pages", len(rescored))
+	}
+}
+
+// ## Noop analyzer
+
+type Noop_analyzer struct{}
+
+func (analyzer *Noop_analyzer) name() string {
+	return ANALYZER_NOOP
+}
+
+func (analyzer *Noop_analyzer) version() string {
+	return "1"
+}
+
+func (analyzer *Noop_analyzer) analyze(document Document) (Annotations, error) {
+	return Annotations{}, nil
+}
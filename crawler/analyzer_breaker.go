@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+var ERROR_CIRCUIT_OPEN = errors.New("analyzer skipped after repeated failures")
+
+// Breaker_analyzer wraps an analyzer that can fail, such as a remote
+// service. After ANALYZER_MAX_FAILURES failures in a row it stops calling it
+// for ANALYZER_COOLDOWN, then lets one call through to check if it's back.
+// Other calls are skipped until that one returns, a success closes the
+// breaker and a failure opens it for another cooldown
+type Breaker_analyzer struct {
+	analyzer   Analyzer
+	lock       sync.Mutex
+	failures   int
+	open_until time.Time
+	probing    bool
+}
+
+func new_breaker_analyzer(analyzer Analyzer) *Breaker_analyzer {
+	return &Breaker_analyzer{analyzer: analyzer}
+}
+
+func (breaker *Breaker_analyzer) name() string {
+	return breaker.analyzer.name()
+}
+
+// version and supports_language don't reach the analyzer while the breaker
+// is open either, for a remote analyzer they ask the service
+func (breaker *Breaker_analyzer) version() string {
+	if breaker.is_open() {
+		return ""
+	}
+	return breaker.analyzer.version()
+}
+
+func (breaker *Breaker_analyzer) supports_language(language string) bool {
+	if breaker.is_open() {
+		return true
+	}
+	return supports_language(breaker.analyzer, language)
+}
+
+func (breaker *Breaker_analyzer) resting_until() time.Time {
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+	return breaker.open_until
+}
+
+func (breaker *Breaker_analyzer) analyze(document Document) (Annotations, error) {
+	if !breaker.allow() {
+		return Annotations{}, ERROR_CIRCUIT_OPEN
+	}
+
+	annotations, err := breaker.analyzer.analyze(document)
+	breaker.record(err != nil)
+	if err != nil {
+		return Annotations{}, err
+	}
+	return annotations, nil
+}
+
+// analyze_batch counts a batch as one call, failed if no document got through
+func (breaker *Breaker_analyzer) analyze_batch(documents []Document) ([]Annotations, []error) {
+	if !breaker.allow() {
+		errs := make([]error, len(documents))
+		for i := range errs {
+			errs[i] = ERROR_CIRCUIT_OPEN
+		}
+		return make([]Annotations, len(documents)), errs
+	}
+
+	results, errs := analyze_all(breaker.analyzer, documents)
+	failed := len(errs) > 0
+	for _, err := range errs {
+		if err == nil {
+			failed = false
+		}
+	}
+	breaker.record(failed)
+	return results, errs
+}
+
+// allow says whether a call may go through, once the cooldown is over the
+// first caller gets to probe the analyzer
+func (breaker *Breaker_analyzer) allow() bool {
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+	if breaker.failures < ANALYZER_MAX_FAILURES {
+		return true
+	}
+	if breaker.probing || time.Now().Before(breaker.open_until) {
+		return false
+	}
+	breaker.probing = true
+	return true
+}
+
+func (breaker *Breaker_analyzer) is_open() bool {
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+	return breaker.failures >= ANALYZER_MAX_FAILURES && (breaker.probing || time.Now().Before(breaker.open_until))
+}
+
+func (breaker *Breaker_analyzer) record(failed bool) {
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+	breaker.probing = false
+	if !failed {
+		breaker.failures = 0
+		return
+	}
+	breaker.failures += 1
+	if breaker.failures >= ANALYZER_MAX_FAILURES {
+		breaker.open_until = time.Now().Add(ANALYZER_COOLDOWN)
+		log.Warn("analyzer keeps failing, skipping it for a while", "analyzer", breaker.name(), "failures", breaker.failures, "cooldown", ANALYZER_COOLDOWN)
+	}
+}
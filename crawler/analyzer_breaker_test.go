@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Fake_analyzer counts its calls and fails while failing is set
+type Fake_analyzer struct {
+	lock    sync.Mutex
+	failing bool
+	calls   int
+}
+
+func (analyzer *Fake_analyzer) name() string {
+	return "fake"
+}
+
+func (analyzer *Fake_analyzer) version() string {
+	return "1"
+}
+
+func (analyzer *Fake_analyzer) analyze(document Document) (Annotations, error) {
+	analyzer.lock.Lock()
+	defer analyzer.lock.Unlock()
+	analyzer.calls += 1
+	if analyzer.failing {
+		return Annotations{}, errors.New("fake failure")
+	}
+	return Annotations{Summary: "summary of " + string(document.URL)}, nil
+}
+
+func (analyzer *Fake_analyzer) set_failing(failing bool) {
+	analyzer.lock.Lock()
+	defer analyzer.lock.Unlock()
+	analyzer.failing = failing
+}
+
+func TestBreakerAnalyzer(t *testing.T) {
+	fake := &Fake_analyzer{failing: true}
+	breaker := new_breaker_analyzer(fake)
+	document := Document{URL: "https://a.com/"}
+
+	for i := 0; i < ANALYZER_MAX_FAILURES; i++ {
+		if _, err := breaker.analyze(document); err == nil || err == ERROR_CIRCUIT_OPEN {
+			t.Fatalf("call %d: got %v, want the analyzer's error", i, err)
+		}
+	}
+	if _, err := breaker.analyze(document); err != ERROR_CIRCUIT_OPEN {
+		t.Errorf("after %d failures got %v, want the breaker open", ANALYZER_MAX_FAILURES, err)
+	}
+	if fake.calls != ANALYZER_MAX_FAILURES {
+		t.Errorf("analyzer called %d times while open", fake.calls)
+	}
+	if breaker.resting_until().Before(time.Now()) {
+		t.Error("resting_until should be in the future while open")
+	}
+
+	// Once the cooldown is over a failed probe opens it again
+	breaker.open_until = time.Now()
+	if _, err := breaker.analyze(document); err == nil || err == ERROR_CIRCUIT_OPEN {
+		t.Errorf("probe got %v, want the analyzer's error", err)
+	}
+	if _, err := breaker.analyze(document); err != ERROR_CIRCUIT_OPEN {
+		t.Errorf("after a failed probe got %v, want the breaker open", err)
+	}
+
+	// And a successful one closes it
+	breaker.open_until = time.Now()
+	fake.set_failing(false)
+	if annotations, err := breaker.analyze(document); err != nil || annotations.Summary == "" {
+		t.Errorf("probe got %v, %v", annotations, err)
+	}
+	if _, err := breaker.analyze(document); err != nil {
+		t.Errorf("after a successful probe got %v", err)
+	}
+}
+
+func TestBreakerAllowsOneProbe(t *testing.T) {
+	breaker := new_breaker_analyzer(&Fake_analyzer{})
+	breaker.failures = ANALYZER_MAX_FAILURES
+	breaker.open_until = time.Now()
+
+	if !breaker.allow() {
+		t.Fatal("the first call after the cooldown should probe")
+	}
+	if breaker.allow() {
+		t.Error("other calls should be skipped while the probe runs")
+	}
+	breaker.record(false)
+	if !breaker.allow() {
+		t.Error("calls should go through once the probe succeeded")
+	}
+}
+
+func TestBreakerBatch(t *testing.T) {
+	fake := &Fake_analyzer{failing: true}
+	breaker := new_breaker_analyzer(fake)
+	documents := []Document{{URL: "https://a.com/1"}, {URL: "https://a.com/2"}}
+
+	// A failed batch counts as one failure, not one per document
+	breaker.analyze_batch(documents)
+	if breaker.failures != 1 {
+		t.Errorf("%d failures after one failed batch, want 1", breaker.failures)
+	}
+	breaker.failures = ANALYZER_MAX_FAILURES
+	breaker.open_until = time.Now().Add(time.Minute)
+	_, errs := breaker.analyze_batch(documents)
+	if len(errs) != len(documents) || errs[0] != ERROR_CIRCUIT_OPEN || errs[1] != ERROR_CIRCUIT_OPEN {
+		t.Errorf("open breaker returned %v", errs)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// The analyzer protocol over gRPC. Messages are the same JSON as over HTTP,
+// sent with the "json" content subtype, so no generated code is needed on
+// either side
+const GRPC_SERVICE = "analyzer." + PROTOCOL_VERSION + ".Analyzer"
+
+type Json_codec struct{}
+
+func (codec Json_codec) Marshal(value interface{}) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (codec Json_codec) Unmarshal(data []byte, value interface{}) error {
+	return json.Unmarshal(data, value)
+}
+
+func (codec Json_codec) Name() string {
+	return "json"
+}
+
+type Capabilities_request struct {
+	Version string `json:"version"`
+}
+
+// Grpc_transport calls /analyzer.v1.Analyzer/Capabilities and
+// /analyzer.v1.Analyzer/Analyze
+type Grpc_transport struct {
+	conn *grpc.ClientConn
+}
+
+// new_grpc_transport doesn't connect yet, that happens on the first call
+func new_grpc_transport(address string) (*Grpc_transport, error) {
+	conn, err := grpc.Dial(address,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(Json_codec{})),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &Grpc_transport{conn: conn}, nil
+}
+
+func (transport *Grpc_transport) capabilities() (Capabilities, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ANALYZER_TIMEOUT)
+	defer cancel()
+
+	var capabilities Capabilities
+	err := transport.conn.Invoke(ctx, "/"+GRPC_SERVICE+"/Capabilities", &Capabilities_request{Version: PROTOCOL_VERSION}, &capabilities)
+	return capabilities, err
+}
+
+func (transport *Grpc_transport) analyze(request Analysis_request) (Analysis_response, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), request.timeout())
+	defer cancel()
+
+	var response Analysis_response
+	err := transport.conn.Invoke(ctx, "/"+GRPC_SERVICE+"/Analyze", &request, &response)
+	if err == nil && response.Error != nil {
+		err = response.Error
+	}
+	return response, err
+}
+
+// A Protocol_service answers the analyzer protocol, served by
+// serve_grpc and serve_http
+type Protocol_service interface {
+	capabilities() Capabilities
+	analyze(request Analysis_request) Analysis_response
+}
+
+var GRPC_SERVICE_DESC = grpc.ServiceDesc{
+	ServiceName: GRPC_SERVICE,
+	HandlerType: (*Protocol_service)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Capabilities",
+			Handler: func(service interface{}, ctx context.Context, decode func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				var request Capabilities_request
+				if err := decode(&request); err != nil {
+					return nil, err
+				}
+				capabilities := service.(Protocol_service).capabilities()
+				return &capabilities, nil
+			},
+		},
+		{
+			MethodName: "Analyze",
+			Handler: func(service interface{}, ctx context.Context, decode func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				var request Analysis_request
+				if err := decode(&request); err != nil {
+					return nil, err
+				}
+				response := service.(Protocol_service).analyze(request)
+				return &response, nil
+			},
+		},
+	},
+}
+
+func new_grpc_server(service Protocol_service) *grpc.Server {
+	server := grpc.NewServer(grpc.ForceServerCodec(Json_codec{}))
+	server.RegisterService(&GRPC_SERVICE_DESC, service)
+	return server
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// Http_transport speaks the analyzer protocol as JSON over HTTP:
+// GET /v1/capabilities and POST /v1/analyze
+type Http_transport struct {
+	base_url string
+	client   *http.Client
+}
+
+func new_http_transport(base_url string) *Http_transport {
+	return &Http_transport{
+		base_url: strings.TrimSuffix(base_url, "/"),
+		client:   &http.Client{},
+	}
+}
+
+func (transport *Http_transport) capabilities() (Capabilities, error) {
+	var capabilities Capabilities
+	ctx, cancel := context.WithTimeout(context.Background(), ANALYZER_TIMEOUT)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, transport.base_url+"/"+PROTOCOL_VERSION+"/capabilities", nil)
+	if err != nil {
+		return capabilities, err
+	}
+	resp, err := transport.client.Do(req)
+	if err != nil {
+		return capabilities, err
+	}
+	defer resp.Body.Close()
+
+	err = read_protocol_response(resp, &capabilities)
+	return capabilities, err
+}
+
+func (transport *Http_transport) analyze(request Analysis_request) (Analysis_response, error) {
+	var response Analysis_response
+	body, err := json.Marshal(request)
+	if err != nil {
+		return response, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), request.timeout())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, transport.base_url+"/"+PROTOCOL_VERSION+"/analyze", bytes.NewBuffer(body))
+	if err != nil {
+		return response, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := transport.client.Do(req)
+	if err != nil {
+		return response, err
+	}
+	defer resp.Body.Close()
+
+	err = read_protocol_response(resp, &response)
+	if err == nil && response.Error != nil {
+		err = response.Error
+	}
+	return response, err
+}
+
+// read_protocol_response decodes a JSON body, anything but a 200 is an
+// error, described by the body's error field when there is one
+func read_protocol_response(resp *http.Response, value interface{}) error {
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Error *Protocol_error `json:"error"`
+		}
+		if json.Unmarshal(body, &failure) == nil && failure.Error != nil {
+			return fmt.Errorf("%s: %w", resp.Status, failure.Error)
+		}
+		return fmt.Errorf("%s returned %s", resp.Request.URL, resp.Status)
+	}
+	return json.Unmarshal(body, value)
+}
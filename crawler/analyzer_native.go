@@ -0,0 +1,106 @@
+package main
+
+import (
+	"sort"
+)
+
+// Native analyzer config
+const NATIVE_SUMMARY_SENTENCES = 3
+const NATIVE_KEYWORD_COUNT = 10
+
+// Native_analyzer summarises by picking the sentences with the most
+// frequent words, and takes those words as keywords. No server needed
+type Native_analyzer struct{}
+
+func (analyzer *Native_analyzer) name() string {
+	return ANALYZER_NATIVE
+}
+
+func (analyzer *Native_analyzer) version() string {
+	return "1"
+}
+
+func (analyzer *Native_analyzer) analyze(document Document) (Annotations, error) {
+	tokens := tokenize(document.Text)
+	frequencies := word_frequencies(tokens, document_language(document, tokens))
+	keywords := top_words(frequencies, NATIVE_KEYWORD_COUNT)
+
+	// A keyword's score is its share of the words
+	scores := make(map[string]float64)
+	for _, keyword := range keywords {
+		scores[keyword] = float64(frequencies[keyword]) / float64(len(tokens))
+	}
+	return Annotations{
+		Summary:        extractive_summary(document.Text, frequencies, NATIVE_SUMMARY_SENTENCES),
+		Keywords:       keywords,
+		Keyword_scores: scores,
+	}, nil
+}
+
+func word_frequencies(tokens []string, language string) map[string]int {
+	frequencies := make(map[string]int)
+	for _, token := range tokens {
+		if !is_stopword(token, language) {
+			frequencies[token] += 1
+		}
+	}
+	return frequencies
+}
+
+// top_words returns the count most frequent words, ties broken alphabetically
+func top_words(frequencies map[string]int, count int) []string {
+	words := []string{}
+	for word := range frequencies {
+		words = append(words, word)
+	}
+	sort.Slice(words, func(i, j int) bool {
+		if frequencies[words[i]] != frequencies[words[j]] {
+			return frequencies[words[i]] > frequencies[words[j]]
+		}
+		return words[i] < words[j]
+	})
+	if len(words) > count {
+		words = words[:count]
+	}
+	return words
+}
+
+// extractive_summary keeps the count best sentences in their original order
+func extractive_summary(text string, frequencies map[string]int, count int) string {
+	sentences := split_sentences(text)
+	scores := make([]float64, len(sentences))
+	for i, sentence := range sentences {
+		tokens := tokenize(sentence)
+		if len(tokens) < 5 {
+			continue
+		}
+		for _, token := range tokens {
+			scores[i] += float64(frequencies[token])
+		}
+		scores[i] /= float64(len(tokens))
+	}
+
+	order := make([]int, len(sentences))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return scores[order[i]] > scores[order[j]]
+	})
+	if len(order) > count {
+		order = order[:count]
+	}
+	sort.Ints(order)
+
+	summary := ""
+	for _, i := range order {
+		if scores[i] == 0 {
+			continue
+		}
+		if summary != "" {
+			summary += " "
+		}
+		summary += sentences[i]
+	}
+	return summary
+}
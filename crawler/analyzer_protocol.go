@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Protocol_transport carries analyzer protocol messages to a service
+type Protocol_transport interface {
+	capabilities() (Capabilities, error)
+	analyze(request Analysis_request) (Analysis_response, error)
+}
+
+// Protocol_analyzer is the client for any service speaking the analyzer
+// protocol. The service's capabilities are discovered on first use
+type Protocol_analyzer struct {
+	analyzer_name string
+	transport     Protocol_transport
+	tasks         []string
+
+	lock            sync.Mutex
+	discovered      Capabilities
+	discovery_error error
+	discovered_at   time.Time
+}
+
+func new_protocol_analyzer(name string, transport Protocol_transport, tasks []string) *Protocol_analyzer {
+	return &Protocol_analyzer{
+		analyzer_name: name,
+		transport:     transport,
+		tasks:         tasks,
+	}
+}
+
+func (analyzer *Protocol_analyzer) name() string {
+	return analyzer.analyzer_name
+}
+
+// version is empty while the service can't be reached. The tasks asked
+// for are part of it, the results depend on them
+func (analyzer *Protocol_analyzer) version() string {
+	capabilities, err := analyzer.capabilities()
+	if err != nil {
+		return ""
+	}
+	return capabilities.Name + "/" + capabilities.Analyzer_version + "/" + strings.Join(analyzer.tasks, ",")
+}
+
+// capabilities asks the service again once the last answer is older than
+// ANALYZER_CAPABILITIES_TTL, or the last failure older than
+// ANALYZER_CAPABILITIES_RETRY, so a service that's down isn't waited on
+// for every page
+func (analyzer *Protocol_analyzer) capabilities() (Capabilities, error) {
+	analyzer.lock.Lock()
+	defer analyzer.lock.Unlock()
+	ttl := ANALYZER_CAPABILITIES_TTL
+	if analyzer.discovery_error != nil {
+		ttl = ANALYZER_CAPABILITIES_RETRY
+	}
+	if !analyzer.discovered_at.IsZero() && time.Since(analyzer.discovered_at) < ttl {
+		return analyzer.discovered, analyzer.discovery_error
+	}
+
+	capabilities, err := analyzer.transport.capabilities()
+	if err == nil && capabilities.Version != PROTOCOL_VERSION {
+		err = fmt.Errorf("analyzer %s speaks protocol %q, expected %q", analyzer.analyzer_name, capabilities.Version, PROTOCOL_VERSION)
+	}
+	analyzer.discovered, analyzer.discovery_error, analyzer.discovered_at = capabilities, err, time.Now()
+	return capabilities, err
+}
+
+// supports_language is true while the service can't be reached, so the
+// failure shows up when analyzing
+func (analyzer *Protocol_analyzer) supports_language(language string) bool {
+	capabilities, err := analyzer.capabilities()
+	if err != nil || len(capabilities.Languages) == 0 {
+		return true
+	}
+	for _, supported := range capabilities.Languages {
+		if supported == language {
+			return true
+		}
+	}
+	return false
+}
+
+func (analyzer *Protocol_analyzer) analyze(document Document) (Annotations, error) {
+	results, errs := analyzer.analyze_batch([]Document{document})
+	return results[0], errs[0]
+}
+
+// analyze_batch sends the documents in as few requests as the service's
+// batch size allows
+func (analyzer *Protocol_analyzer) analyze_batch(documents []Document) ([]Annotations, []error) {
+	results := make([]Annotations, len(documents))
+	errs := make([]error, len(documents))
+
+	capabilities, err := analyzer.capabilities()
+	if err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return results, errs
+	}
+
+	tasks := []string{}
+	for _, task := range analyzer.tasks {
+		if capabilities.supports_task(task) {
+			tasks = append(tasks, task)
+		}
+	}
+
+	for start := 0; start < len(documents); start += batch_size(capabilities) {
+		end := start + batch_size(capabilities)
+		if end > len(documents) {
+			end = len(documents)
+		}
+		for i, result := range analyzer.request(tasks, documents[start:end], errs[start:end]) {
+			if errs[start+i] == nil {
+				results[start+i] = annotations_from_result(result)
+			}
+		}
+	}
+	return results, errs
+}
+
+func batch_size(capabilities Capabilities) int {
+	if capabilities.Max_batch_size < 1 {
+		return 1
+	}
+	return capabilities.Max_batch_size
+}
+
+// request makes one request, returns the result for each document and
+// fills in errs for the documents that failed
+func (analyzer *Protocol_analyzer) request(tasks []string, documents []Document, errs []error) []Protocol_result {
+	results := make([]Protocol_result, len(documents))
+	request := Analysis_request{
+		Version: PROTOCOL_VERSION,
+		Tasks:   tasks,
+	}
+	for i, document := range documents {
+		request.Documents = append(request.Documents, Protocol_document{
+			ID:       strconv.Itoa(i),
+			URL:      document.URL,
+			Language: document.Language,
+			Text:     document.Text,
+		})
+	}
+
+	response, err := analyzer.transport.analyze(request)
+	if err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return results
+	}
+
+	answered := make([]bool, len(documents))
+	for _, result := range response.Results {
+		i, err := strconv.Atoi(result.ID)
+		if err != nil || i < 0 || i >= len(documents) {
+			continue
+		}
+		answered[i] = true
+		if result.Error != nil {
+			errs[i] = result.Error
+			continue
+		}
+		results[i] = result
+	}
+	for i := range documents {
+		if !answered[i] {
+			errs[i] = fmt.Errorf("analyzer %s sent no result for %s", analyzer.analyzer_name, documents[i].URL)
+		}
+	}
+	return results
+}
+
+func annotations_from_result(result Protocol_result) Annotations {
+	annotations := Annotations{Summary: result.Summary}
+	for _, keyword := range result.Keywords {
+		annotations.Keywords = append(annotations.Keywords, keyword.Term)
+		if keyword.Score > 0 {
+			if annotations.Keyword_scores == nil {
+				annotations.Keyword_scores = make(map[string]float64)
+			}
+			annotations.Keyword_scores[keyword.Term] = keyword.Score
+		}
+	}
+	for _, entity := range result.Entities {
+		annotations.Entities = append(annotations.Entities, Named_entity{
+			Key:           named_entity_key(entity.Kind, entity.Name),
+			Name:          entity.Name,
+			Kind:          entity.Kind,
+			Mention_count: entity.Count,
+		})
+	}
+	return annotations
+}
+
+// embed asks the service for an embedding of each document, in batches
+func (analyzer *Protocol_analyzer) embed(documents []Document) ([][]float64, []error) {
+	embeddings := make([][]float64, len(documents))
+	errs := make([]error, len(documents))
+
+	capabilities, err := analyzer.capabilities()
+	if err == nil && !capabilities.supports_task(TASK_EMBEDDING) {
+		err = fmt.Errorf("analyzer %s can't embed pages", analyzer.analyzer_name)
+	}
+	if err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return embeddings, errs
+	}
+
+	for start := 0; start < len(documents); start += batch_size(capabilities) {
+		end := start + batch_size(capabilities)
+		if end > len(documents) {
+			end = len(documents)
+		}
+		results := analyzer.request([]string{TASK_EMBEDDING}, documents[start:end], errs[start:end])
+		for i, result := range results {
+			if errs[start+i] == nil {
+				embeddings[start+i] = result.Embedding
+			}
+		}
+	}
+	return embeddings, errs
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Fake_transport answers with a fixed capabilities and records the requests
+type Fake_transport struct {
+	capabilities_answer Capabilities
+	capabilities_error  error
+	capabilities_calls  int
+	requests            []Analysis_request
+	// Ids of the documents left out of the response, and those failed
+	skip map[string]bool
+	fail map[string]bool
+}
+
+func (transport *Fake_transport) capabilities() (Capabilities, error) {
+	transport.capabilities_calls += 1
+	return transport.capabilities_answer, transport.capabilities_error
+}
+
+func (transport *Fake_transport) analyze(request Analysis_request) (Analysis_response, error) {
+	transport.requests = append(transport.requests, request)
+	response := Analysis_response{Version: PROTOCOL_VERSION}
+	for _, document := range request.Documents {
+		switch {
+		case transport.skip[document.ID]:
+		case transport.fail[document.ID]:
+			response.Results = append(response.Results, Protocol_result{ID: document.ID, Error: &Protocol_error{Code: ERROR_INTERNAL, Message: "failed"}})
+		default:
+			response.Results = append(response.Results, Protocol_result{
+				ID:       document.ID,
+				Summary:  "summary of " + string(document.URL),
+				Keywords: []Protocol_keyword{{Term: "gopher", Score: 0.5}, {Term: "crawler"}},
+				Entities: []Protocol_entity{{Name: "Google", Kind: ENTITY_ORGANIZATION, Count: 2}},
+			})
+		}
+	}
+	return response, nil
+}
+
+func test_documents(count int) []Document {
+	documents := []Document{}
+	for i := 0; i < count; i++ {
+		documents = append(documents, Document{URL: URL("https://a.com/" + string(rune('a'+i))), Text: "text"})
+	}
+	return documents
+}
+
+func TestProtocolAnalyzerBatches(t *testing.T) {
+	transport := &Fake_transport{capabilities_answer: Capabilities{
+		Version: PROTOCOL_VERSION, Name: "fake", Analyzer_version: "1",
+		Tasks: []string{TASK_SUMMARY, TASK_KEYWORDS, TASK_ENTITIES}, Max_batch_size: 2,
+	}}
+	analyzer := new_protocol_analyzer("fake", transport, []string{TASK_SUMMARY, TASK_KEYWORDS, TASK_EMBEDDING})
+
+	results, errs := analyzer.analyze_batch(test_documents(5))
+	if len(transport.requests) != 3 {
+		t.Errorf("%d requests, want 5 documents split in batches of 2", len(transport.requests))
+	}
+	// Tasks the service lacks aren't asked for
+	if tasks := strings.Join(transport.requests[0].Tasks, ","); tasks != "summary,keywords" {
+		t.Errorf("asked for %s", tasks)
+	}
+	for i, result := range results {
+		if errs[i] != nil || result.Summary != "summary of https://a.com/"+string(rune('a'+i)) {
+			t.Errorf("document %d got %+v, %v", i, result, errs[i])
+		}
+	}
+	if results[0].Keyword_scores["gopher"] != 0.5 || len(results[0].Keywords) != 2 || len(results[0].Entities) != 1 {
+		t.Errorf("annotations %+v", results[0])
+	}
+	if version := analyzer.version(); version != "fake/1/summary,keywords,embedding" {
+		t.Errorf("version %q", version)
+	}
+	if transport.capabilities_calls != 1 {
+		t.Errorf("capabilities asked %d times, want once", transport.capabilities_calls)
+	}
+}
+
+func TestProtocolAnalyzerDocumentErrors(t *testing.T) {
+	transport := &Fake_transport{
+		capabilities_answer: Capabilities{Version: PROTOCOL_VERSION, Tasks: []string{TASK_SUMMARY}, Max_batch_size: 8},
+		skip:                map[string]bool{"1": true},
+		fail:                map[string]bool{"2": true},
+	}
+	analyzer := new_protocol_analyzer("fake", transport, []string{TASK_SUMMARY})
+
+	_, errs := analyzer.analyze_batch(test_documents(3))
+	if errs[0] != nil || errs[1] == nil || errs[2] == nil {
+		t.Errorf("errors %v, want the skipped and failed documents to fail alone", errs)
+	}
+	var protocol_error *Protocol_error
+	if !errors.As(errs[2], &protocol_error) || protocol_error.Code != ERROR_INTERNAL {
+		t.Errorf("failed document got %v", errs[2])
+	}
+}
+
+func TestProtocolAnalyzerDiscovery(t *testing.T) {
+	transport := &Fake_transport{capabilities_answer: Capabilities{Version: "v0", Max_batch_size: 8}}
+	analyzer := new_protocol_analyzer("fake", transport, []string{TASK_SUMMARY})
+
+	if _, err := analyzer.analyze(Document{URL: "https://a.com/"}); err == nil {
+		t.Error("a service speaking another version should fail")
+	}
+	if analyzer.version() != "" || !analyzer.supports_language(LANGUAGE_ARABIC) {
+		t.Error("an unusable service has no version and doesn't filter languages")
+	}
+	if len(transport.requests) != 0 {
+		t.Error("nothing should be sent to an unusable service")
+	}
+
+	// The failure is remembered until ANALYZER_CAPABILITIES_RETRY
+	analyzer.analyze(Document{URL: "https://a.com/"})
+	if transport.capabilities_calls != 1 {
+		t.Errorf("capabilities asked %d times", transport.capabilities_calls)
+	}
+	transport.capabilities_answer = Capabilities{Version: PROTOCOL_VERSION, Languages: []string{LANGUAGE_ENGLISH}, Max_batch_size: 8}
+	analyzer.discovered_at = time.Now().Add(-ANALYZER_CAPABILITIES_RETRY)
+	if _, err := analyzer.analyze(Document{URL: "https://a.com/"}); err != nil {
+		t.Error(err)
+	}
+	if analyzer.supports_language(LANGUAGE_ARABIC) || !analyzer.supports_language(LANGUAGE_ENGLISH) {
+		t.Error("languages should follow the capabilities")
+	}
+}
+
+func TestValidateAnalysisRequest(t *testing.T) {
+	capabilities := Capabilities{Version: PROTOCOL_VERSION, Tasks: []string{TASK_SUMMARY}, Max_batch_size: 1}
+	tests := []struct {
+		request Analysis_request
+		want    string
+	}{
+		{Analysis_request{Version: PROTOCOL_VERSION, Tasks: []string{TASK_SUMMARY}, Documents: []Protocol_document{{ID: "0"}}}, ""},
+		{Analysis_request{Version: "v0"}, ERROR_UNSUPPORTED_VERSION},
+		{Analysis_request{Version: PROTOCOL_VERSION, Tasks: []string{TASK_EMBEDDING}}, ERROR_UNSUPPORTED_TASK},
+		{Analysis_request{Version: PROTOCOL_VERSION, Documents: []Protocol_document{{ID: "0"}, {ID: "1"}}}, ERROR_BATCH_TOO_LARGE},
+	}
+	for _, test := range tests {
+		got := ""
+		if err := validate_analysis_request(test.request, capabilities); err != nil {
+			got = err.Code
+		}
+		if got != test.want {
+			t.Errorf("validate_analysis_request(%+v) = %q, want %q", test.request, got, test.want)
+		}
+	}
+}
+
+func TestAnalysisRequestTimeout(t *testing.T) {
+	request := Analysis_request{Documents: make([]Protocol_document, ANALYSIS_BATCH_SIZE)}
+	if got := request.timeout(); got != ANALYZER_TIMEOUT+ANALYSIS_BATCH_SIZE*ANALYZER_DOCUMENT_TIMEOUT {
+		t.Errorf("a full batch gets %v", got)
+	}
+}
+
+// Both transports talk to the stub the same way
+func TestProtocolTransports(t *testing.T) {
+	service := &Stub_service{analyzers: []Analyzer{&Native_analyzer{}}}
+
+	server := httptest.NewServer(new_http_handler(service))
+	defer server.Close()
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	grpc_server := new_grpc_server(service)
+	go grpc_server.Serve(listener)
+	defer grpc_server.Stop()
+	grpc_transport, err := new_grpc_transport(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transports := map[string]Protocol_transport{
+		ANALYZER_HTTP: new_http_transport(server.URL),
+		ANALYZER_GRPC: grpc_transport,
+	}
+	text := "Gophers crawl the web. The web is big and gophers are small. Crawling the web takes gophers a long time."
+	for name, transport := range transports {
+		capabilities, err := transport.capabilities()
+		if err != nil || capabilities.Name != "stub" || capabilities.Max_batch_size != STUB_MAX_BATCH_SIZE {
+			t.Errorf("%s: capabilities %+v, %v", name, capabilities, err)
+		}
+
+		analyzer := new_protocol_analyzer(name, transport, []string{TASK_SUMMARY, TASK_KEYWORDS})
+		annotations, err := analyzer.analyze(Document{URL: "https://a.com/", Text: text})
+		if err != nil || annotations.Summary == "" || len(annotations.Keywords) == 0 {
+			t.Errorf("%s: got %+v, %v", name, annotations, err)
+		}
+
+		// A request failing as a whole comes back as its protocol error
+		_, err = transport.analyze(Analysis_request{Version: "v0"})
+		var protocol_error *Protocol_error
+		if !errors.As(err, &protocol_error) || protocol_error.Code != ERROR_UNSUPPORTED_VERSION {
+			t.Errorf("%s: bad version got %v", name, err)
+		}
+	}
+}
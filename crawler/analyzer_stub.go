@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/charmbracelet/log"
+)
+
+const STUB_MAX_BATCH_SIZE = 32
+
+// Stub_service answers the analyzer protocol with the Go analyzers, for
+// running the crawler against a service without the Python dependencies
+type Stub_service struct {
+	analyzers []Analyzer
+}
+
+func (stub *Stub_service) capabilities() Capabilities {
+	return Capabilities{
+		Version:          PROTOCOL_VERSION,
+		Name:             "stub",
+		Analyzer_version: stub.version(),
+		Tasks:            []string{TASK_SUMMARY, TASK_KEYWORDS, TASK_ENTITIES, TASK_EMBEDDING},
+		Max_batch_size:   STUB_MAX_BATCH_SIZE,
+	}
+}
+
+func (stub *Stub_service) version() string {
+	versions := []string{}
+	for _, analyzer := range stub.analyzers {
+		versions = append(versions, analyzer.name()+"-"+analyzer.version())
+	}
+	return strings.Join(versions, "+")
+}
+
+func (stub *Stub_service) analyze(request Analysis_request) Analysis_response {
+	response := Analysis_response{Version: PROTOCOL_VERSION, Results: []Protocol_result{}}
+	if err := validate_analysis_request(request, stub.capabilities()); err != nil {
+		response.Error = err
+		return response
+	}
+
+	for _, document := range request.Documents {
+		result := Protocol_result{ID: document.ID}
+		page := Document{URL: document.URL, Text: document.Text, Language: document.Language}
+		annotations, err := stub.analyze_document(page)
+		if err != nil {
+			result.Error = &Protocol_error{Code: ERROR_INTERNAL, Message: err.Error()}
+			response.Results = append(response.Results, result)
+			continue
+		}
+		for _, task := range request.Tasks {
+			switch task {
+			case TASK_SUMMARY:
+				result.Summary = annotations.Summary
+			case TASK_KEYWORDS:
+				for _, keyword := range annotations.Keywords {
+					result.Keywords = append(result.Keywords, Protocol_keyword{Term: keyword, Score: annotations.Keyword_scores[keyword]})
+				}
+			case TASK_ENTITIES:
+				for _, entity := range annotations.Entities {
+					result.Entities = append(result.Entities, Protocol_entity{Name: entity.Name, Kind: entity.Kind, Count: entity.Mention_count})
+				}
+			case TASK_EMBEDDING:
+				result.Embedding = hashed_embedding(page)
+			}
+		}
+		response.Results = append(response.Results, result)
+	}
+	return response
+}
+
+func (stub *Stub_service) analyze_document(document Document) (Annotations, error) {
+	annotations := Annotations{}
+	for _, analyzer := range stub.analyzers {
+		result, err := analyzer.analyze(document)
+		if err != nil {
+			return Annotations{}, err
+		}
+		annotations.merge(result)
+	}
+	return annotations, nil
+}
+
+// validate_analysis_request checks a request against what a service can do
+func validate_analysis_request(request Analysis_request, capabilities Capabilities) *Protocol_error {
+	if request.Version != PROTOCOL_VERSION {
+		return &Protocol_error{Code: ERROR_UNSUPPORTED_VERSION, Message: "expected version " + PROTOCOL_VERSION}
+	}
+	for _, task := range request.Tasks {
+		if !capabilities.supports_task(task) {
+			return &Protocol_error{Code: ERROR_UNSUPPORTED_TASK, Message: "unknown task " + task}
+		}
+	}
+	if len(request.Documents) > capabilities.Max_batch_size {
+		return &Protocol_error{Code: ERROR_BATCH_TOO_LARGE, Message: "too many documents"}
+	}
+	return nil
+}
+
+// serve_http serves the analyzer protocol as JSON over HTTP
+func serve_http(address string, service Protocol_service) error {
+	return http.ListenAndServe(address, new_http_handler(service))
+}
+
+func new_http_handler(service Protocol_service) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+PROTOCOL_VERSION+"/capabilities", func(w http.ResponseWriter, r *http.Request) {
+		write_json(w, http.StatusOK, service.capabilities())
+	})
+	mux.HandleFunc("/"+PROTOCOL_VERSION+"/analyze", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			write_json(w, http.StatusMethodNotAllowed, Analysis_response{Version: PROTOCOL_VERSION, Error: &Protocol_error{Code: ERROR_BAD_REQUEST, Message: "POST only"}})
+			return
+		}
+		var request Analysis_request
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			write_json(w, http.StatusBadRequest, Analysis_response{Version: PROTOCOL_VERSION, Error: &Protocol_error{Code: ERROR_BAD_REQUEST, Message: err.Error()}})
+			return
+		}
+		response := service.analyze(request)
+		status := http.StatusOK
+		if response.Error != nil {
+			status = http.StatusBadRequest
+		}
+		write_json(w, status, response)
+	})
+	return mux
+}
+
+func write_json(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// serve_grpc serves the analyzer protocol over gRPC
+func serve_grpc(address string, service Protocol_service) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	return new_grpc_server(service).Serve(listener)
+}
+
+// stub_command serves the stub on both transports until killed
+func stub_command() {
+	service := &Stub_service{analyzers: []Analyzer{&Native_analyzer{}, new_entity_analyzer()}}
+	http_address := strings.TrimPrefix(ANALYZER_URL, "http://")
+	log.Info("Analyzer stub listening", "http", http_address, "grpc", ANALYZER_GRPC_ADDRESS)
+
+	go func() {
+		log.Fatal(serve_grpc(ANALYZER_GRPC_ADDRESS, service))
+	}()
+	log.Fatal(serve_http(http_address, service))
+}
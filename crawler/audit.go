@@ -0,0 +1,268 @@
+package main
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/dgraph-io/dgo/v2"
+	"github.com/jedib0t/go-pretty/v6/table"
+)
+
+// Severities of audit issues, in the order they're reported
+const SEVERITY_ERROR = "error"
+const SEVERITY_WARNING = "warning"
+const SEVERITY_NOTICE = "notice"
+const SEVERITY_OFF = "off" // The rule isn't run
+
+var SEVERITY_ORDER = [...]string{SEVERITY_ERROR, SEVERITY_WARNING, SEVERITY_NOTICE}
+
+// Audit rules
+const RULE_MISSING_TITLE = "missing_title"
+const RULE_DUPLICATE_TITLE = "duplicate_title"
+const RULE_LONG_TITLE = "long_title"
+const RULE_MISSING_DESCRIPTION = "missing_description"
+const RULE_MULTIPLE_H1 = "multiple_h1"
+const RULE_MISSING_ALT = "missing_alt"
+const RULE_NON_CANONICAL_IN_SITEMAP = "non_canonical_in_sitemap"
+const RULE_NOINDEX_LINKED = "noindex_linked"
+
+// Audit config
+// Severity of each rule, rules without one are off
+var AUDIT_SEVERITIES = map[string]string{
+	RULE_MISSING_TITLE:            SEVERITY_ERROR,
+	RULE_DUPLICATE_TITLE:          SEVERITY_WARNING,
+	RULE_LONG_TITLE:               SEVERITY_NOTICE,
+	RULE_MISSING_DESCRIPTION:      SEVERITY_WARNING,
+	RULE_MULTIPLE_H1:              SEVERITY_NOTICE,
+	RULE_MISSING_ALT:              SEVERITY_NOTICE,
+	RULE_NON_CANONICAL_IN_SITEMAP: SEVERITY_WARNING,
+	RULE_NOINDEX_LINKED:           SEVERITY_WARNING,
+}
+
+// Titles longer than this are cut off in search results
+const MAX_TITLE_LENGTH = 60
+
+// Pages listed per rule in the report
+const AUDIT_EXAMPLES = 3
+
+// An Issue is a problem a rule found with a page in one run, stored as a
+// node linked from the page with issues
+type Issue struct {
+	UID      string   `json:"uid,omitempty"`
+	Rule     string   `json:"issue_rule,omitempty"`
+	Severity string   `json:"issue_severity,omitempty"`
+	Message  string   `json:"issue_message,omitempty"`
+	Run      string   `json:"issue_run,omitempty"`
+	DType    []string `json:"dgraph.type,omitempty"`
+	page     URL
+}
+
+// Audit_site is what rules look at: the crawled pages, by url, every url
+// the crawl met with the page it resolved to, and the sitemaps
+type Audit_site struct {
+	pages    []*Page
+	urls     map[URL]*Page
+	sitemaps map[URL][]URL
+}
+
+// An Audit_rule returns the issues it finds, severity and run are filled in
+type Audit_rule func(site *Audit_site) []Issue
+
+var AUDIT_RULES = map[string]Audit_rule{
+	RULE_MISSING_TITLE: page_rule(func(page *Page) string {
+		if strings.TrimSpace(page.Title) == "" {
+			return "no <title>"
+		}
+		return ""
+	}),
+	RULE_DUPLICATE_TITLE: duplicate_titles,
+	RULE_LONG_TITLE: page_rule(func(page *Page) string {
+		if length := len([]rune(strings.TrimSpace(page.Title))); length > MAX_TITLE_LENGTH {
+			return "title is " + strconv.Itoa(length) + " characters"
+		}
+		return ""
+	}),
+	RULE_MISSING_DESCRIPTION: page_rule(func(page *Page) string {
+		if page.Description == "" {
+			return "no meta description"
+		}
+		return ""
+	}),
+	RULE_MULTIPLE_H1: page_rule(func(page *Page) string {
+		if page.H1_count > 1 {
+			return strconv.Itoa(page.H1_count) + " <h1> elements"
+		}
+		return ""
+	}),
+	RULE_MISSING_ALT: page_rule(func(page *Page) string {
+		if page.Missing_alt > 0 {
+			return strconv.Itoa(page.Missing_alt) + " images without alt"
+		}
+		return ""
+	}),
+	RULE_NON_CANONICAL_IN_SITEMAP: non_canonical_in_sitemap,
+	RULE_NOINDEX_LINKED: func(site *Audit_site) []Issue {
+		issues := []Issue{}
+		for _, page := range site.pages {
+			if page.Noindex && page.In_degree > 0 {
+				issues = append(issues, Issue{page: page.URL, Message: "noindex, linked from " + strconv.Itoa(page.In_degree) + " pages"})
+			}
+		}
+		return issues
+	},
+}
+
+// Order the rules run in
+var AUDIT_RULE_ORDER = [...]string{
+	RULE_MISSING_TITLE, RULE_DUPLICATE_TITLE, RULE_LONG_TITLE, RULE_MISSING_DESCRIPTION,
+	RULE_MULTIPLE_H1, RULE_MISSING_ALT, RULE_NON_CANONICAL_IN_SITEMAP, RULE_NOINDEX_LINKED,
+}
+
+// audit_pages runs the rules that aren't off over the crawled pages and
+// stores the issues, replacing the ones of earlier runs
+func audit_pages(index *Index, dg *dgo.Dgraph) []Issue {
+	site := new_audit_site(index)
+
+	issues := []Issue{}
+	for _, rule := range AUDIT_RULE_ORDER {
+		severity := AUDIT_SEVERITIES[rule]
+		if severity == "" || severity == SEVERITY_OFF {
+			continue
+		}
+		for _, issue := range AUDIT_RULES[rule](site) {
+			issue.Rule = rule
+			issue.Severity = severity
+			issue.Run = index.crawl.Run
+			issue.DType = []string{"Issue"}
+			issues = append(issues, issue)
+		}
+	}
+
+	// Pages without issues lose the ones of earlier runs too
+	urls := []URL{}
+	audited := make(map[URL]bool)
+	for _, page := range site.pages {
+		urls = append(urls, page.URL)
+		audited[page.URL] = true
+	}
+	for _, issue := range issues {
+		if !audited[issue.page] {
+			urls = append(urls, issue.page)
+			audited[issue.page] = true
+		}
+	}
+	Db_set_issues(dg, urls, issues)
+	log.Info("Audited pages", "pages", len(site.pages), "issues", len(issues))
+	return issues
+}
+
+func new_audit_site(index *Index) *Audit_site {
+	site := &Audit_site{
+		urls:     make(map[URL]*Page),
+		sitemaps: index.sitemaps,
+	}
+	index.lock.Lock()
+	defer index.lock.Unlock()
+	for url, page := range index.inprogress_or_done_pages {
+		site.urls[url] = page
+		if page.URL == url && page.Is_crawled {
+			site.pages = append(site.pages, page)
+		}
+	}
+	sort.Slice(site.pages, func(i, j int) bool {
+		return site.pages[i].URL < site.pages[j].URL
+	})
+	return site
+}
+
+// page_rule makes a rule out of a check on a single page, returning what's
+// wrong or "". Noindex pages are skipped, search results never show them
+func page_rule(check func(page *Page) string) Audit_rule {
+	return func(site *Audit_site) []Issue {
+		issues := []Issue{}
+		for _, page := range site.pages {
+			if page.Noindex {
+				continue
+			}
+			if message := check(page); message != "" {
+				issues = append(issues, Issue{page: page.URL, Message: message})
+			}
+		}
+		return issues
+	}
+}
+
+// duplicate_titles flags every indexed page sharing its title with another
+func duplicate_titles(site *Audit_site) []Issue {
+	by_title := make(map[string][]*Page)
+	for _, page := range site.pages {
+		title := strings.ToLower(strings.Join(strings.Fields(page.Title), " "))
+		if !page.Noindex && title != "" {
+			by_title[title] = append(by_title[title], page)
+		}
+	}
+
+	issues := []Issue{}
+	for _, page := range site.pages {
+		title := strings.ToLower(strings.Join(strings.Fields(page.Title), " "))
+		if same := by_title[title]; title != "" && len(same) > 1 && !page.Noindex {
+			issues = append(issues, Issue{page: page.URL, Message: "title shared with " + strconv.Itoa(len(same)-1) + " other pages"})
+		}
+	}
+	return issues
+}
+
+// non_canonical_in_sitemap flags sitemap urls the crawl found to be aliases
+// of another page, the sitemap should list the canonical url
+func non_canonical_in_sitemap(site *Audit_site) []Issue {
+	urls := []URL{}
+	for url := range site.sitemaps {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+
+	issues := []Issue{}
+	for _, url := range urls {
+		if page, ok := site.urls[url]; ok && page.URL != url && page.Is_crawled {
+			issues = append(issues, Issue{page: url, Message: "canonical is " + page.URL})
+		}
+	}
+	return issues
+}
+
+// display_issues counts the issues of each rule, most severe first
+func display_issues(issues []Issue) {
+	if len(issues) == 0 {
+		return
+	}
+	by_rule := make(map[string][]Issue)
+	for _, issue := range issues {
+		by_rule[issue.Rule] = append(by_rule[issue.Rule], issue)
+	}
+
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"Severity", "Rule", "Pages", "Examples"})
+	for _, severity := range SEVERITY_ORDER {
+		for _, rule := range AUDIT_RULE_ORDER {
+			found := by_rule[rule]
+			if len(found) == 0 || found[0].Severity != severity {
+				continue
+			}
+			examples := []string{}
+			for _, issue := range found {
+				if len(examples) == AUDIT_EXAMPLES {
+					break
+				}
+				examples = append(examples, issue.page+" ("+issue.Message+")")
+			}
+			t.AppendRow(table.Row{severity, rule, len(found), strings.Join(examples, "\n")})
+		}
+	}
+	t.SetColumnConfigs([]table.ColumnConfig{{Number: 1, AutoMerge: true}})
+	t.SetTitle("Audit")
+	t.Render()
+}
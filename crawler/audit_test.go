@@ -0,0 +1,92 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func audit_test_site() *Audit_site {
+	long_title := strings.Repeat("a", MAX_TITLE_LENGTH+1)
+	pages := []*Page{
+		{URL: "https://a.com", Title: "Home", Description: "The home page", Is_crawled: true},
+		{URL: "https://a.com/untitled", Title: " ", Description: "d", Is_crawled: true},
+		{URL: "https://a.com/long", Title: long_title, Description: "d", Is_crawled: true},
+		{URL: "https://a.com/bare", Title: "Bare", Is_crawled: true},
+		{URL: "https://a.com/h1", Title: "H1", Description: "d", H1_count: 2, Missing_alt: 3, Is_crawled: true},
+		{URL: "https://a.com/copy-1", Title: "Same  title", Description: "d", Is_crawled: true},
+		{URL: "https://a.com/copy-2", Title: "same title", Description: "d", Is_crawled: true},
+		// Noindex pages are left out of page rules, unless they're linked
+		{URL: "https://a.com/hidden", Title: "Same title", Noindex: true, Is_crawled: true, Link_scores: Link_scores{In_degree: 2}},
+		{URL: "https://a.com/private", Noindex: true, Is_crawled: true},
+	}
+	index := &Index{inprogress_or_done_pages: make(map[URL]*Page), sitemaps: map[URL][]URL{
+		"https://a.com/old-home": {"https://a.com/sitemap.xml"},
+		"https://a.com/bare":     {"https://a.com/sitemap.xml"},
+	}}
+	for _, page := range pages {
+		index.inprogress_or_done_pages[page.URL] = page
+	}
+	// An alias of the home page, listed in the sitemap
+	index.inprogress_or_done_pages["https://a.com/old-home"] = pages[0]
+	return new_audit_site(index)
+}
+
+func TestAuditRules(t *testing.T) {
+	site := audit_test_site()
+	tests := map[string][]URL{
+		RULE_MISSING_TITLE:            {"https://a.com/untitled"},
+		RULE_DUPLICATE_TITLE:          {"https://a.com/copy-1", "https://a.com/copy-2"},
+		RULE_LONG_TITLE:               {"https://a.com/long"},
+		RULE_MISSING_DESCRIPTION:      {"https://a.com/bare"},
+		RULE_MULTIPLE_H1:              {"https://a.com/h1"},
+		RULE_MISSING_ALT:              {"https://a.com/h1"},
+		RULE_NON_CANONICAL_IN_SITEMAP: {"https://a.com/old-home"},
+		RULE_NOINDEX_LINKED:           {"https://a.com/hidden"},
+	}
+	for _, rule := range AUDIT_RULE_ORDER {
+		want, ok := tests[rule]
+		if !ok {
+			t.Errorf("no test for rule %s", rule)
+			continue
+		}
+		got := []URL{}
+		for _, issue := range AUDIT_RULES[rule](site) {
+			got = append(got, issue.page)
+			if issue.Message == "" {
+				t.Errorf("%s: %s has no message", rule, issue.page)
+			}
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", rule, got, want)
+		}
+	}
+}
+
+func TestAuditRulesHaveSeverities(t *testing.T) {
+	for _, rule := range AUDIT_RULE_ORDER {
+		if AUDIT_RULES[rule] == nil {
+			t.Errorf("rule %s isn't defined", rule)
+		}
+		if _, ok := AUDIT_SEVERITIES[rule]; !ok {
+			t.Errorf("rule %s has no severity", rule)
+		}
+	}
+	if len(AUDIT_RULES) != len(AUDIT_RULE_ORDER) {
+		t.Errorf("%d rules defined, %d ordered", len(AUDIT_RULES), len(AUDIT_RULE_ORDER))
+	}
+}
+
+func TestNewAuditSite(t *testing.T) {
+	site := audit_test_site()
+	for i := 1; i < len(site.pages); i++ {
+		if site.pages[i-1].URL >= site.pages[i].URL {
+			t.Errorf("pages not sorted: %s before %s", site.pages[i-1].URL, site.pages[i].URL)
+		}
+	}
+	if len(site.pages) != 9 || site.urls["https://a.com/old-home"].URL != "https://a.com" {
+		t.Errorf("%d pages, alias resolves to %s", len(site.pages), site.urls["https://a.com/old-home"].URL)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Reasons a url is an alias of a canonical page
+const ALIAS_REDIRECT = "redirect"
+const ALIAS_CANONICAL = "canonical"
+
+// get_canonical_url reads <link rel="canonical">
+func get_canonical_url(doc *goquery.Document, current_url URL) (URL, bool) {
+	canonical_url := ""
+	doc.Find("link[rel][href]").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		rel, _ := s.Attr("rel")
+		if !has_rel(rel, "canonical") {
+			return true
+		}
+		canonical_url, _ = s.Attr("href")
+		return false
+	})
+	if canonical_url == "" {
+		return "", false
+	}
+	return validate_url(strings.TrimSpace(canonical_url), current_url)
+}
+
+// get_language_alternates reads <link rel="alternate" hreflang="..."> and
+// maps each variant url to its language
+func get_language_alternates(doc *goquery.Document, current_url URL) map[URL]string {
+	alternates := make(map[URL]string)
+	doc.Find("link[hreflang][href]").Each(func(i int, s *goquery.Selection) {
+		rel, _ := s.Attr("rel")
+		if !has_rel(rel, "alternate") {
+			return
+		}
+		href, _ := s.Attr("href")
+		url, ok := validate_url(strings.TrimSpace(href), current_url)
+		if !ok || url == current_url {
+			return
+		}
+		hreflang, _ := s.Attr("hreflang")
+		alternates[url] = strings.ToLower(strings.TrimSpace(hreflang))
+	})
+	return alternates
+}
+
+// resolve_canonical_page points the page at its canonical url and records
+// every other url it was reached by as an alias. A canonical on another site
+// is ignored, any page can claim one and its content would replace the
+// other site's page
+func resolve_canonical_page(page *Page, doc *goquery.Document, final_url URL) {
+	aliases := make(map[URL]string)
+	canonical_url := page.URL
+
+	if redirected_url, ok := validate_url(final_url, page.URL); ok && redirected_url != page.URL {
+		aliases[page.URL] = ALIAS_REDIRECT
+		canonical_url = redirected_url
+	}
+
+	if url, ok := get_canonical_url(doc, canonical_url); ok && url != canonical_url && same_site(url, canonical_url) {
+		aliases[canonical_url] = ALIAS_CANONICAL
+		canonical_url = url
+	}
+
+	page.URL = canonical_url
+	for url, reason := range aliases {
+		page.Aliases = append(page.Aliases, Page{
+			URL: url,
+			Link_facets: Link_facets{
+				Alias_reason: reason,
+			},
+		})
+	}
+}
+
+func same_site(a URL, b URL) bool {
+	domain_a, err_a := domain_name(a)
+	domain_b, err_b := domain_name(b)
+	return err_a == nil && err_b == nil && domain_a == domain_b
+}
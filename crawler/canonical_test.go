@@ -0,0 +1,63 @@
+package main
+
+import "testing"
+
+func TestResolveCanonicalPage(t *testing.T) {
+	tests := []struct {
+		name      string
+		url       URL
+		final_url URL
+		head      string
+		want_url  URL
+		// Alias urls with their reason
+		want_aliases map[URL]string
+	}{
+		{"plain page", "https://a.com/x", "https://a.com/x", "", "https://a.com/x", map[URL]string{}},
+		{"redirect", "https://a.com/old", "https://a.com/new", "", "https://a.com/new", map[URL]string{"https://a.com/old": ALIAS_REDIRECT}},
+		{
+			"canonical",
+			"https://a.com/x?ref=1", "https://a.com/x?ref=1", `<link rel="canonical" href="/x">`,
+			"https://a.com/x", map[URL]string{"https://a.com/x?ref=1": ALIAS_CANONICAL},
+		},
+		{
+			"redirect then canonical",
+			"http://a.com/old", "https://www.a.com/new", `<link rel="canonical" href="https://a.com/new">`,
+			"https://a.com/new", map[URL]string{"http://a.com/old": ALIAS_REDIRECT, "https://www.a.com/new": ALIAS_CANONICAL},
+		},
+		{"canonical to itself", "https://a.com/x", "https://a.com/x", `<link rel="canonical" href="https://a.com/x">`, "https://a.com/x", map[URL]string{}},
+		{"canonical on another site", "https://a.com/x", "https://a.com/x", `<link rel="canonical" href="https://b.org/x">`, "https://a.com/x", map[URL]string{}},
+	}
+	for _, test := range tests {
+		page := &Page{URL: test.url}
+		resolve_canonical_page(page, parse_html(t, "<html><head>"+test.head+"</head></html>"), test.final_url)
+		if page.URL != test.want_url {
+			t.Errorf("%s: url %s, want %s", test.name, page.URL, test.want_url)
+		}
+		aliases := make(map[URL]string)
+		for _, alias := range page.Aliases {
+			aliases[alias.URL] = alias.Alias_reason
+		}
+		if len(aliases) != len(test.want_aliases) {
+			t.Errorf("%s: aliases %v, want %v", test.name, aliases, test.want_aliases)
+			continue
+		}
+		for url, reason := range test.want_aliases {
+			if aliases[url] != reason {
+				t.Errorf("%s: aliases %v, want %v", test.name, aliases, test.want_aliases)
+			}
+		}
+	}
+}
+
+func TestGetLanguageAlternates(t *testing.T) {
+	doc := parse_html(t, `<html><head>
+		<link rel="alternate" hreflang="EN" href="https://a.com/en/">
+		<link rel="alternate" hreflang="ar" href="/ar/">
+		<link rel="alternate" hreflang="fr" href="https://a.com/fr/">
+		<link rel="stylesheet" hreflang="de" href="/de.css">
+	</head></html>`)
+	alternates := get_language_alternates(doc, "https://a.com/fr")
+	if len(alternates) != 2 || alternates["https://a.com/en"] != "en" || alternates["https://a.com/ar"] != "ar" {
+		t.Errorf("alternates %v, want en and ar, without the page itself or the stylesheet", alternates)
+	}
+}
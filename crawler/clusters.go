@@ -0,0 +1,225 @@
+package main
+
+import (
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/dgraph-io/dgo/v2"
+	"github.com/jedib0t/go-pretty/v6/table"
+)
+
+// Clustering config
+// Pages are grouped in this many clusters by k-means over the page
+// vectors, 0 to skip
+const CLUSTER_COUNT = 8
+const CLUSTER_ITERATIONS = 20
+
+// Top keywords of a cluster's pages used as its label
+const CLUSTER_LABEL_KEYWORDS = 3
+
+// Pages closest to a cluster's centre shown in the report
+const CLUSTER_EXAMPLES = 3
+
+// A Cluster groups the pages of a run with similar content
+type Cluster struct {
+	UID      string   `json:"uid,omitempty"`
+	ID       string   `json:"cluster_id,omitempty"`
+	Run      string   `json:"cluster_run,omitempty"`
+	Label    string   `json:"cluster_label,omitempty"`
+	Keywords []string `json:"cluster_keywords,omitempty"`
+	Size     int      `json:"cluster_size,omitempty"`
+	DType    []string `json:"dgraph.type,omitempty"`
+	pages    []URL
+	examples []URL
+}
+
+// cluster_pages groups the pages with k-means, labels each cluster with
+// the topics most common among its pages and stores the clusters
+func cluster_pages(documents []Document, vectors []Vector, index *Index, dg *dgo.Dgraph) []Cluster {
+	if CLUSTER_COUNT == 0 || len(documents) < 2 {
+		return nil
+	}
+	k := CLUSTER_COUNT
+	if k > len(documents) {
+		k = len(documents)
+	}
+
+	assignments, centroids := kmeans(vectors, k)
+
+	clusters := make([]Cluster, k)
+	for i := range clusters {
+		clusters[i] = Cluster{
+			ID:    index.crawl.Run + "/" + strconv.Itoa(i+1),
+			Run:   index.crawl.Run,
+			DType: []string{"Cluster"},
+		}
+	}
+	for i, cluster := range assignments {
+		clusters[cluster].pages = append(clusters[cluster].pages, documents[i].URL)
+		clusters[cluster].Size += 1
+	}
+
+	for c := range clusters {
+		// Pages closest to the centre first
+		members := []int{}
+		for i, cluster := range assignments {
+			if cluster == c {
+				members = append(members, i)
+			}
+		}
+		sort.SliceStable(members, func(a, b int) bool {
+			return dot(vectors[members[a]], centroids[c]) > dot(vectors[members[b]], centroids[c])
+		})
+		for _, i := range members {
+			if len(clusters[c].examples) == CLUSTER_EXAMPLES {
+				break
+			}
+			clusters[c].examples = append(clusters[c].examples, documents[i].URL)
+		}
+
+		clusters[c].Keywords = cluster_keywords(clusters[c].pages, index)
+		clusters[c].Label = strings.Join(clusters[c].Keywords, ", ")
+		if clusters[c].Label == "" {
+			clusters[c].Label = "cluster " + strconv.Itoa(c+1)
+		}
+	}
+
+	// Empty clusters are left out
+	kept := []Cluster{}
+	for _, cluster := range clusters {
+		if cluster.Size > 0 {
+			Db_set_cluster(dg, cluster)
+			kept = append(kept, cluster)
+		}
+	}
+	sort.SliceStable(kept, func(i, j int) bool {
+		return kept[i].Size > kept[j].Size
+	})
+	log.Info("Clustered pages", "pages", len(documents), "clusters", len(kept))
+	return kept
+}
+
+// kmeans is spherical k-means, on normalized vectors by cosine similarity.
+// Centres start k-means++ style from a fixed seed so runs are repeatable
+func kmeans(vectors []Vector, k int) ([]int, []Vector) {
+	random := rand.New(rand.NewSource(1))
+
+	centroids := []Vector{vectors[random.Intn(len(vectors))]}
+	for len(centroids) < k {
+		// Pick the next centre with probability growing with the distance
+		// to the closest centre so far
+		distances := make([]float64, len(vectors))
+		total := 0.0
+		for i, vector := range vectors {
+			closest := 0.0
+			for _, centroid := range centroids {
+				if similarity := dot(vector, centroid); similarity > closest {
+					closest = similarity
+				}
+			}
+			distances[i] = 1 - closest
+			total += distances[i]
+		}
+		if total == 0 {
+			break
+		}
+		target := random.Float64() * total
+		next := len(vectors) - 1
+		for i, distance := range distances {
+			target -= distance
+			if target <= 0 {
+				next = i
+				break
+			}
+		}
+		centroids = append(centroids, vectors[next])
+	}
+
+	assignments := make([]int, len(vectors))
+	for iteration := 0; iteration < CLUSTER_ITERATIONS; iteration++ {
+		changed := iteration == 0
+		for i, vector := range vectors {
+			best, best_similarity := 0, -1.0
+			for c, centroid := range centroids {
+				if similarity := dot(vector, centroid); similarity > best_similarity {
+					best, best_similarity = c, similarity
+				}
+			}
+			if assignments[i] != best {
+				assignments[i] = best
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+
+		sums := make([]Vector, len(centroids))
+		for c := range sums {
+			sums[c] = make(Vector)
+		}
+		for i, vector := range vectors {
+			for dimension, value := range vector {
+				sums[assignments[i]][dimension] += value
+			}
+		}
+		for c := range centroids {
+			// A centre that lost all its pages keeps its place
+			if len(sums[c]) > 0 {
+				centroids[c] = normalize(sums[c])
+			}
+		}
+	}
+	return assignments, centroids
+}
+
+// cluster_keywords are the topics most pages of the cluster have, by
+// summed score
+func cluster_keywords(pages []URL, index *Index) []string {
+	scores := make(map[string]float64)
+	index.lock.Lock()
+	for _, url := range pages {
+		page, ok := index.inprogress_or_done_pages[url]
+		if !ok {
+			continue
+		}
+		for _, topic := range page.Topics {
+			// Unscored topics still count once
+			scores[topic.Name] += 1 + topic.Topic_score
+		}
+	}
+	index.lock.Unlock()
+
+	keywords := []string{}
+	for keyword := range scores {
+		keywords = append(keywords, keyword)
+	}
+	sort.Slice(keywords, func(i, j int) bool {
+		if scores[keywords[i]] != scores[keywords[j]] {
+			return scores[keywords[i]] > scores[keywords[j]]
+		}
+		return keywords[i] < keywords[j]
+	})
+	if len(keywords) > CLUSTER_LABEL_KEYWORDS {
+		keywords = keywords[:CLUSTER_LABEL_KEYWORDS]
+	}
+	return keywords
+}
+
+func display_clusters(clusters []Cluster) {
+	if len(clusters) == 0 {
+		return
+	}
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"Cluster", "Pages", "Examples"})
+	for _, cluster := range clusters {
+		t.AppendRow(table.Row{cluster.Label, cluster.Size, strings.Join(cluster.examples, "\n")})
+	}
+	t.SetTitle("Page clusters")
+	t.Render()
+}
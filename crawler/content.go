@@ -0,0 +1,181 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// Content extraction config
+// Below this share of the page's text the best candidate is assumed to be
+// wrong and the whole body is used instead
+const MIN_MAIN_CONTENT_SHARE = 0.25
+
+// Elements that never hold the main content of a page. Forms aren't, some
+// sites wrap the whole page in one, only their controls are dropped
+const BOILERPLATE_SELECTOR = "script, style, noscript, template, svg, canvas, iframe, select, button, textarea, nav, footer, aside, " +
+	"[role=navigation], [role=banner], [role=contentinfo], [role=complementary], [hidden], [aria-hidden=true]"
+
+// Headers are boilerplate at the page level, in an article they hold its title
+const CONTENT_ELEMENTS = "article, main, [role=main]"
+
+// Classes and ids of boilerplate containers. Only whole classes match, so
+// "has-comments" or "entry-header" on real content don't
+var BOILERPLATE_PATTERN = regexp.MustCompile(`(?i)^(nav|navbar|menu|sidebar|footer|header|breadcrumbs?|comments?|share|social|related|promo|ads?|advert\w*|banner|cookies?|popup|modal|newsletter|subscribe)$`)
+
+// Elements whose class or id is checked against BOILERPLATE_PATTERN
+var CONTAINER_ELEMENTS = map[string]bool{
+	"div": true, "section": true, "aside": true, "ul": true, "ol": true, "dl": true, "table": true,
+}
+
+var BLOCK_ELEMENTS = map[string]bool{
+	"p": true, "div": true, "section": true, "article": true, "main": true, "br": true, "hr": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"ul": true, "ol": true, "li": true, "dl": true, "dt": true, "dd": true,
+	"table": true, "tr": true, "td": true, "th": true, "blockquote": true, "pre": true, "figcaption": true,
+}
+
+type Content struct {
+	text       string
+	headings   []string
+	word_count int
+}
+
+// extract_content finds the main content of a page, readability style, and
+// returns its text without navigation, ads and other boilerplate
+func extract_content(doc *goquery.Document) Content {
+	clean := goquery.CloneDocument(doc)
+	clean.Find(BOILERPLATE_SELECTOR).Remove()
+	clean.Find("header").FilterFunction(func(i int, s *goquery.Selection) bool {
+		return s.ParentsFiltered(CONTENT_ELEMENTS).Length() == 0
+	}).Remove()
+	clean.Find("[class], [id]").Each(func(i int, s *goquery.Selection) {
+		if CONTAINER_ELEMENTS[goquery.NodeName(s)] && is_boilerplate(s) {
+			s.Remove()
+		}
+	})
+
+	body := clean.Find("body")
+	main := find_main_content(body)
+
+	content := Content{}
+	content.text = node_text(main)
+	content.word_count = len(strings.Fields(content.text))
+	main.Find("h1, h2, h3, h4, h5, h6").Each(func(i int, s *goquery.Selection) {
+		heading := strings.Join(strings.Fields(s.Text()), " ")
+		if heading != "" {
+			content.headings = append(content.headings, heading)
+		}
+	})
+	return content
+}
+
+func is_boilerplate(s *goquery.Selection) bool {
+	class, _ := s.Attr("class")
+	id, _ := s.Attr("id")
+	for _, name := range append(strings.Fields(class), id) {
+		if BOILERPLATE_PATTERN.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// find_main_content picks the element holding the most paragraph text,
+// discounting text that sits inside links. Listing pages with several
+// articles get all of them
+func find_main_content(body *goquery.Selection) *goquery.Selection {
+	if landmark := body.Find("main, [role=main]").First(); landmark.Length() > 0 {
+		return landmark
+	}
+	articles := body.Find("article").FilterFunction(func(i int, s *goquery.Selection) bool {
+		return s.ParentsFiltered("article").Length() == 0
+	})
+	if articles.Length() > 0 {
+		return articles
+	}
+
+	scores := make(map[*html.Node]float64)
+	candidates := make(map[*html.Node]*goquery.Selection)
+	body.Find("p, pre, blockquote, td, li").Each(func(i int, s *goquery.Selection) {
+		text_length := float64(len(strings.Join(strings.Fields(s.Text()), " ")))
+		if text_length < 25 {
+			return
+		}
+		score := text_length * (1 - link_density(s))
+
+		// The parent gets the full score and the grandparent half, like readability
+		parent := s.Parent()
+		if parent.Length() > 0 {
+			scores[parent.Get(0)] += score
+			candidates[parent.Get(0)] = parent
+		}
+		grandparent := parent.Parent()
+		if grandparent.Length() > 0 {
+			scores[grandparent.Get(0)] += score / 2
+			candidates[grandparent.Get(0)] = grandparent
+		}
+	})
+
+	var best *goquery.Selection
+	best_score := 0.0
+	for node, score := range scores {
+		if score > best_score {
+			best, best_score = candidates[node], score
+		}
+	}
+
+	body_length := len(strings.Join(strings.Fields(body.Text()), " "))
+	if best == nil || float64(len(strings.Join(strings.Fields(best.Text()), " "))) < MIN_MAIN_CONTENT_SHARE*float64(body_length) {
+		return body
+	}
+	return best
+}
+
+// link_density is the share of an element's text that sits inside links
+func link_density(s *goquery.Selection) float64 {
+	text_length := len(s.Text())
+	if text_length == 0 {
+		return 0
+	}
+	link_length := 0
+	s.Find("a").Each(func(i int, a *goquery.Selection) {
+		link_length += len(a.Text())
+	})
+	return float64(link_length) / float64(text_length)
+}
+
+// node_text returns the text of an element with one line per block
+func node_text(s *goquery.Selection) string {
+	var builder strings.Builder
+	var walk func(node *html.Node)
+	walk = func(node *html.Node) {
+		switch node.Type {
+		case html.TextNode:
+			builder.WriteString(node.Data)
+			return
+		case html.ElementNode:
+			if BLOCK_ELEMENTS[node.Data] {
+				builder.WriteString("\n")
+				defer builder.WriteString("\n")
+			}
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	for _, node := range s.Nodes {
+		walk(node)
+	}
+
+	lines := []string{}
+	for _, line := range strings.Split(builder.String(), "\n") {
+		line = strings.Join(strings.Fields(line), " ")
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
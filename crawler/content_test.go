@@ -0,0 +1,71 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const CONTENT_TEST_PARAGRAPH = "The crawler stores every page it reads with the links it found on it."
+
+func TestExtractContent(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		text     string
+		headings []string
+	}{
+		{
+			"main landmark",
+			`<header><h1>Site</h1></header><nav><a href="/">Home</a></nav><main><h1>Title</h1><p>` + CONTENT_TEST_PARAGRAPH + `</p></main><footer>Copyright</footer>`,
+			"Title\n" + CONTENT_TEST_PARAGRAPH,
+			[]string{"Title"},
+		},
+		{
+			"article header kept",
+			`<header>Site</header><article><header><h1>Title</h1><p>By Sarah</p></header><p>` + CONTENT_TEST_PARAGRAPH + `</p></article>`,
+			"Title\nBy Sarah\n" + CONTENT_TEST_PARAGRAPH,
+			[]string{"Title"},
+		},
+		{
+			"page wrapped in a form",
+			`<form action="/postback"><input type="hidden" name="state"><div id="content"><h2>Section</h2><p>` + CONTENT_TEST_PARAGRAPH + `</p></div><button>Search</button></form>`,
+			"Section\n" + CONTENT_TEST_PARAGRAPH,
+			[]string{"Section"},
+		},
+		{
+			"every article",
+			`<article><h2>One</h2><p>First.</p></article><aside>Ads</aside><article><h2>Two</h2><p>Second.</p></article>`,
+			"One\nFirst.\nTwo\nSecond.",
+			[]string{"One", "Two"},
+		},
+		{
+			"whole boilerplate classes only",
+			`<main><div class="sidebar">Popular posts</div><div class="entry-header has-comments"><p>` + CONTENT_TEST_PARAGRAPH + `</p></div><p class="share">kept, not a container</p></main>`,
+			CONTENT_TEST_PARAGRAPH + "\nkept, not a container",
+			nil,
+		},
+	}
+	for _, test := range tests {
+		content := extract_content(parse_html(t, "<html><body>"+test.body+"</body></html>"))
+		if content.text != test.text {
+			t.Errorf("%s: text %q, want %q", test.name, content.text, test.text)
+		}
+		if !reflect.DeepEqual(content.headings, test.headings) {
+			t.Errorf("%s: headings %q, want %q", test.name, content.headings, test.headings)
+		}
+		if content.word_count != len(strings.Fields(test.text)) {
+			t.Errorf("%s: word count %d", test.name, content.word_count)
+		}
+	}
+}
+
+func TestLinkDensity(t *testing.T) {
+	doc := parse_html(t, `<p id="links"><a>one</a> <a>two</a></p><p id="text">plain text</p>`)
+	if density := link_density(doc.Find("#links")); density < 0.8 {
+		t.Errorf("link density of a list of links %f", density)
+	}
+	if density := link_density(doc.Find("#text")); density != 0 {
+		t.Errorf("link density of plain text %f", density)
+	}
+}
@@ -2,6 +2,10 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/charmbracelet/log"
 	"github.com/dgraph-io/dgo/v2"
@@ -9,19 +13,25 @@ import (
 	"google.golang.org/grpc"
 )
 
-func Db_setup() *dgo.Dgraph {
-	// DB setup
+func Db_connect() *dgo.Dgraph {
 	d, err := grpc.Dial("localhost:9080", grpc.WithInsecure())
 	if err != nil {
 		panic(err)
 	}
 
-	dg := dgo.NewDgraphClient(api.NewDgraphClient(d))
+	return dgo.NewDgraphClient(api.NewDgraphClient(d))
+}
+
+func Db_setup() *dgo.Dgraph {
+	// DB setup
+	dg := Db_connect()
 
 	// Drop all data
-	err = dg.Alter(context.Background(), &api.Operation{DropAll: true})
-	if err != nil {
-		panic(err)
+	if DROP_ALL_ON_START {
+		err := dg.Alter(context.Background(), &api.Operation{DropAll: true})
+		if err != nil {
+			panic(err)
+		}
 	}
 
 	op := &api.Operation{}
@@ -29,7 +39,7 @@ func Db_setup() *dgo.Dgraph {
 		url: string @index(exact) .
 		domain: uid @reverse .
 		title: string @index(exact) .
-		related_pages: [uid] .
+		related_pages: [uid] @reverse .
 		is_crawled: bool @index(bool) .
 		depth: int @index(int) .
 		time_crawled: datetime @index(hour) .
@@ -37,6 +47,77 @@ func Db_setup() *dgo.Dgraph {
 		summary: string @index(fulltext) .
         keywords: [string] @index(fulltext) .
 		name: string @index(exact) .
+		content_hash: string @index(exact) .
+		text: string @index(fulltext) .
+		headings: [string] @index(term) .
+		word_count: int @index(int) .
+		language: string @index(exact) .
+		noindex: bool @index(bool) .
+		aliases: [uid] @reverse .
+		translations: [uid] @reverse .
+		links_discarded: int .
+		sitemaps: [string] @index(exact) .
+		h1_count: int @index(int) .
+		missing_alt: int @index(int) .
+		issues: [uid] @reverse .
+		issue_rule: string @index(exact) .
+		issue_severity: string @index(exact) .
+		issue_message: string .
+		issue_run: string @index(exact) .
+		fingerprint: string @index(exact) .
+		duplicate_of: uid @reverse .
+		similar_to: [uid] .
+		cluster: uid @reverse .
+		pagerank: float @index(float) .
+		hub_score: float @index(float) .
+		authority_score: float @index(float) .
+		in_degree: int @index(int) .
+		out_degree: int @index(int) .
+		component: int @index(int) .
+		component_size: int @index(int) .
+		community: int @index(int) .
+		community_size: int @index(int) .
+		click_depth: int @index(int) .
+		click_parent: uid .
+		cluster_id: string @index(exact) .
+		cluster_run: string @index(exact) .
+		cluster_label: string @index(term) .
+		cluster_keywords: [string] @index(exact) .
+		cluster_size: int @index(int) .
+		description: string @index(fulltext) .
+		og_title: string @index(exact) .
+		og_description: string .
+		og_type: string @index(exact) .
+		og_image: string .
+		og_site_name: string @index(exact) .
+		og_url: string @index(exact) .
+		twitter_card: string @index(exact) .
+		twitter_title: string .
+		twitter_description: string .
+		twitter_image: string .
+		twitter_site: string @index(exact) .
+		describes: [uid] @reverse .
+		topics: [uid] @reverse .
+		topic_name: string @index(exact, term) .
+		co_occurs_with: [uid] .
+		mentions: [uid] @reverse .
+		named_entity_key: string @index(exact) .
+		named_entity_name: string @index(exact, term) .
+		named_entity_kind: string @index(exact) .
+		entity_id: string @index(exact) .
+		schema_type: string @index(exact) .
+		entity_name: string @index(exact, term) .
+		entity_url: string @index(exact) .
+		entity_description: string .
+		entity_image: string .
+		schema_json: string .
+		nofollow: bool @index(bool) .
+		snapshots: [uid] @reverse .
+		run: string @index(exact) .
+		seed: string @index(exact) .
+		links: [string] .
+		snapshot_url: string @index(exact) .
+		time_started: datetime @index(hour) .
 	`
 	if err := dg.Alter(context.Background(), op); err != nil {
 		log.Fatal(err)
@@ -44,3 +125,483 @@ func Db_setup() *dgo.Dgraph {
 
 	return dg
 }
+
+func Db_add_crawl(dg *dgo.Dgraph, crawl *Crawl) {
+	crawl.DType = []string{"Crawl"}
+	crawlBytes, err := json.Marshal(crawl)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	mu := &api.Mutation{
+		SetJson:   crawlBytes,
+		CommitNow: true,
+	}
+	if _, err := dg.NewTxn().Mutate(context.Background(), mu); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Db_update_annotations replaces the summary, topics and entities of a page,
+// and the summary and keywords of its snapshot in the current run
+func Db_update_annotations(dg *dgo.Dgraph, page *Page, crawl *Crawl) {
+	upsert := new_upsert()
+	page_uid := upsert.match("url", page.URL)
+	snapshot_uid := upsert.match_filtered("snapshot_url", page.URL, "run", crawl.Run)
+
+	topics := []Topic{}
+	for _, topic := range page.Topics {
+		topic.DType = []string{"Topic"}
+		topic.UID = upsert.match("topic_name", topic.Name)
+		topics = append(topics, topic)
+	}
+	mentions := []Named_entity{}
+	for _, entity := range page.Mentions {
+		entity.DType = []string{"Entity"}
+		entity.UID = upsert.match("named_entity_key", entity.Key)
+		mentions = append(mentions, entity)
+	}
+
+	set := []map[string]interface{}{
+		{"uid": page_uid, "summary": page.Summary, "topics": topics, "mentions": mentions},
+		{"uid": snapshot_uid, "summary": page.Summary, "keywords": page.Keywords},
+	}
+	setBytes, err := json.Marshal(set)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	req := &api.Request{
+		Query:     upsert.query(),
+		Vars:      upsert.vars,
+		CommitNow: true,
+		Mutations: []*api.Mutation{{
+			Cond: "@if(" + upsert.exists(page_uid) + " AND " + upsert.exists(snapshot_uid) + ")",
+			// Pages stored before topics had keywords, they're dropped too
+			DelNquads: []byte(page_uid + " <keywords> * .\n" + page_uid + " <topics> * .\n" + page_uid + " <mentions> * .\n" + snapshot_uid + " <keywords> * ."),
+			SetJson:   setBytes,
+		}},
+	}
+	if _, err := dg.NewTxn().Do(context.Background(), req); err != nil {
+		log.Warn("could not update annotations", "URL", page.URL, "err", err)
+	}
+}
+
+// Db_set_cooccurrences replaces the co_occurs_with edges of a topic
+func Db_set_cooccurrences(dg *dgo.Dgraph, name string, neighbours []Topic) {
+	upsert := new_upsert()
+	topic_uid := upsert.match("topic_name", name)
+	for i := range neighbours {
+		neighbours[i].DType = []string{"Topic"}
+		neighbours[i].UID = upsert.match("topic_name", neighbours[i].Name)
+	}
+
+	topic := map[string]interface{}{
+		"uid":            topic_uid,
+		"topic_name":     name,
+		"dgraph.type":    []string{"Topic"},
+		"co_occurs_with": neighbours,
+	}
+	setBytes, err := json.Marshal(topic)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	req := &api.Request{
+		Query:     upsert.query(),
+		Vars:      upsert.vars,
+		CommitNow: true,
+		Mutations: []*api.Mutation{{
+			DelNquads: []byte(topic_uid + " <co_occurs_with> * ."),
+			SetJson:   setBytes,
+		}},
+	}
+	if _, err := dg.NewTxn().Do(context.Background(), req); err != nil {
+		log.Warn("could not update topic", "topic", name, "err", err)
+	}
+}
+
+// Db_set_similar_pages replaces the similar_to edges of a page
+func Db_set_similar_pages(dg *dgo.Dgraph, url URL, similar []Page) {
+	upsert := new_upsert()
+	page_uid := upsert.match("url", url)
+	for i := range similar {
+		similar[i].UID = upsert.match("url", similar[i].URL)
+	}
+
+	setBytes, err := json.Marshal(map[string]interface{}{"uid": page_uid, "similar_to": similar})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	req := &api.Request{
+		Query:     upsert.query(),
+		Vars:      upsert.vars,
+		CommitNow: true,
+		Mutations: []*api.Mutation{{
+			Cond:      "@if(" + upsert.exists(page_uid) + ")",
+			DelNquads: []byte(page_uid + " <similar_to> * ."),
+			SetJson:   setBytes,
+		}},
+	}
+	if _, err := dg.NewTxn().Do(context.Background(), req); err != nil {
+		log.Warn("could not update similar pages", "URL", url, "err", err)
+	}
+}
+
+// Db_set_cluster stores a cluster and moves its pages into it, in batches.
+// Each batch matches the cluster by its id, the first one creates it
+func Db_set_cluster(dg *dgo.Dgraph, cluster Cluster) {
+	const batch_size = 100
+	for start := 0; start < len(cluster.pages); start += batch_size {
+		end := start + batch_size
+		if end > len(cluster.pages) {
+			end = len(cluster.pages)
+		}
+
+		upsert := new_upsert()
+		cluster.UID = upsert.match("cluster_id", cluster.ID)
+		set := []interface{}{cluster}
+		del := []string{}
+		for _, url := range cluster.pages[start:end] {
+			page_uid := upsert.match("url", url)
+			set = append(set, map[string]interface{}{"uid": page_uid, "cluster": map[string]string{"uid": cluster.UID}})
+			del = append(del, page_uid+" <cluster> * .")
+		}
+		setBytes, err := json.Marshal(set)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		req := &api.Request{
+			Query:     upsert.query(),
+			Vars:      upsert.vars,
+			CommitNow: true,
+			Mutations: []*api.Mutation{{
+				DelNquads: []byte(strings.Join(del, "\n")),
+				SetJson:   setBytes,
+			}},
+		}
+		if _, err := dg.NewTxn().Do(context.Background(), req); err != nil {
+			log.Warn("could not store cluster", "cluster", cluster.Label, "err", err)
+		}
+	}
+}
+
+// Db_load_link_graph loads the related_pages graph between the pages of a
+// domain crawled in any run, with their links as of their latest crawl.
+// Only links the spiders follow count
+func Db_load_link_graph(dg *dgo.Dgraph, domain string) *Link_graph {
+	query := `query graph($domain: string) {
+		var(func: eq(name, $domain)) {
+			p as ~domain
+		}
+		pages(func: uid(p)) @filter(eq(is_crawled, true)) {
+			url
+			related_pages @facets(kind) {
+				url
+			}
+		}
+	}`
+	resp, err := dg.NewReadOnlyTxn().QueryWithVars(context.Background(), query, map[string]string{"$domain": domain})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var result struct {
+		Pages []Page `json:"pages"`
+	}
+	if err := json.Unmarshal(resp.Json, &result); err != nil {
+		log.Fatal(err)
+	}
+
+	// Sorted so pages are numbered the same way on each load
+	sort.Slice(result.Pages, func(i, j int) bool {
+		return result.Pages[i].URL < result.Pages[j].URL
+	})
+	graph := new_link_graph()
+	for _, page := range result.Pages {
+		graph.node(page.URL)
+	}
+	for _, page := range result.Pages {
+		for _, related_page := range page.Related_pages {
+			// Pages stored before link kinds were recorded have none
+			if related_page.Link_kind == "" || CRAWLED_LINK_KINDS[related_page.Link_kind] {
+				graph.add_edge(page.URL, related_page.URL)
+			}
+		}
+	}
+	return graph
+}
+
+// Db_load_inlinked_pages loads every page of a domain, crawled or not, with
+// the sitemaps listing it and the crawled pages linking to it
+func Db_load_inlinked_pages(dg *dgo.Dgraph, domain string) []Inlinked_page {
+	query := `query pages($domain: string) {
+		var(func: eq(name, $domain)) {
+			p as ~domain
+		}
+		pages(func: uid(p)) {
+			url
+			sitemaps
+			~related_pages @filter(eq(is_crawled, true)) @facets(kind) {
+				url
+				domain {
+					name
+				}
+			}
+		}
+	}`
+	resp, err := dg.NewReadOnlyTxn().QueryWithVars(context.Background(), query, map[string]string{"$domain": domain})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var result struct {
+		Pages []Inlinked_page `json:"pages"`
+	}
+	if err := json.Unmarshal(resp.Json, &result); err != nil {
+		log.Fatal(err)
+	}
+	return result.Pages
+}
+
+// Db_set_link_scores stores the link graph scores of the pages, in batches
+func Db_set_link_scores(dg *dgo.Dgraph, urls []URL, scores []Link_scores) {
+	const batch_size = 100
+	for start := 0; start < len(urls); start += batch_size {
+		end := start + batch_size
+		if end > len(urls) {
+			end = len(urls)
+		}
+
+		upsert := new_upsert()
+		set := []map[string]interface{}{}
+		for i := start; i < end; i++ {
+			set = append(set, map[string]interface{}{
+				"uid":             upsert.match("url", urls[i]),
+				"pagerank":        scores[i].Pagerank,
+				"hub_score":       scores[i].Hub_score,
+				"authority_score": scores[i].Authority_score,
+				"in_degree":       scores[i].In_degree,
+				"out_degree":      scores[i].Out_degree,
+			})
+		}
+		setBytes, err := json.Marshal(set)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		req := &api.Request{
+			Query:     upsert.query(),
+			Vars:      upsert.vars,
+			CommitNow: true,
+			Mutations: []*api.Mutation{{SetJson: setBytes}},
+		}
+		if _, err := dg.NewTxn().Do(context.Background(), req); err != nil {
+			log.Warn("could not store link scores", "err", err)
+		}
+	}
+}
+
+// Db_add_sitemap_pages records the sitemaps listing each page, in batches.
+// Pages nothing links to yet are created
+func Db_add_sitemap_pages(dg *dgo.Dgraph, urls []URL, listed map[URL][]URL) {
+	const batch_size = 100
+	for start := 0; start < len(urls); start += batch_size {
+		end := start + batch_size
+		if end > len(urls) {
+			end = len(urls)
+		}
+
+		upsert := new_upsert()
+		pages := []Page{}
+		for _, url := range urls[start:end] {
+			page := Page{UID: upsert.match("url", url), URL: url, Sitemaps: listed[url]}
+			if domain, err := domain_name(url); err == nil {
+				page.Domain = Domain{UID: upsert.match("name", domain), Name: domain}
+			}
+			pages = append(pages, page)
+		}
+		setBytes, err := json.Marshal(pages)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		req := &api.Request{
+			Query:     upsert.query(),
+			Vars:      upsert.vars,
+			CommitNow: true,
+			Mutations: []*api.Mutation{{SetJson: setBytes}},
+		}
+		if _, err := dg.NewTxn().Do(context.Background(), req); err != nil {
+			log.Warn("could not store sitemap pages", "err", err)
+		}
+	}
+}
+
+// Db_set_issues replaces the issues of the pages, in batches. The old Issue
+// nodes are deleted along with the edges to them
+func Db_set_issues(dg *dgo.Dgraph, urls []URL, issues []Issue) {
+	by_page := make(map[URL][]Issue)
+	for _, issue := range issues {
+		by_page[issue.page] = append(by_page[issue.page], issue)
+	}
+
+	const batch_size = 100
+	for start := 0; start < len(urls); start += batch_size {
+		end := start + batch_size
+		if end > len(urls) {
+			end = len(urls)
+		}
+
+		upsert := new_upsert()
+		set := []map[string]interface{}{}
+		del := []string{}
+		for _, url := range urls[start:end] {
+			page_uid := upsert.match("url", url)
+			del = append(del, page_uid+" <issues> * .", upsert.linked("url", url, "issues")+" * * .")
+			if len(by_page[url]) > 0 {
+				set = append(set, map[string]interface{}{"uid": page_uid, "issues": by_page[url]})
+			}
+		}
+		setBytes, err := json.Marshal(set)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		req := &api.Request{
+			Query:     upsert.query(),
+			Vars:      upsert.vars,
+			CommitNow: true,
+			Mutations: []*api.Mutation{{
+				DelNquads: []byte(strings.Join(del, "\n")),
+				SetJson:   setBytes,
+			}},
+		}
+		if _, err := dg.NewTxn().Do(context.Background(), req); err != nil {
+			log.Warn("could not store issues", "err", err)
+		}
+	}
+}
+
+// Db_set_graph_stats stores where each page sits in the link graph, in
+// batches. Pages the seed doesn't reach lose their click depth
+func Db_set_graph_stats(dg *dgo.Dgraph, graph *Link_graph, stats Graph_stats) {
+	const batch_size = 100
+	for start := 0; start < len(graph.urls); start += batch_size {
+		end := start + batch_size
+		if end > len(graph.urls) {
+			end = len(graph.urls)
+		}
+
+		upsert := new_upsert()
+		set := []map[string]interface{}{}
+		del := []string{}
+		for i := start; i < end; i++ {
+			page_uid := upsert.match("url", graph.urls[i])
+			page := map[string]interface{}{
+				"uid":            page_uid,
+				"component":      stats.component[i],
+				"component_size": stats.component_size[stats.component[i]],
+				"community":      stats.community[i],
+				"community_size": stats.community_size[stats.community[i]],
+			}
+			del = append(del, page_uid+" <click_depth> * .", page_uid+" <click_parent> * .")
+			if stats.click_depth[i] >= 0 {
+				page["click_depth"] = stats.click_depth[i]
+			}
+			if parent := stats.click_parent[i]; parent >= 0 {
+				page["click_parent"] = map[string]string{"uid": upsert.match("url", graph.urls[parent])}
+			}
+			set = append(set, page)
+		}
+		setBytes, err := json.Marshal(set)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		req := &api.Request{
+			Query:     upsert.query(),
+			Vars:      upsert.vars,
+			CommitNow: true,
+			Mutations: []*api.Mutation{{
+				DelNquads: []byte(strings.Join(del, "\n")),
+				SetJson:   setBytes,
+			}},
+		}
+		if _, err := dg.NewTxn().Do(context.Background(), req); err != nil {
+			log.Warn("could not store graph stats", "err", err)
+		}
+	}
+}
+
+// Upsert builds the query block of an upsert, one uid variable per matched node
+type Upsert struct {
+	blocks []string
+	params []string
+	vars   map[string]string
+	uids   map[string]string
+}
+
+func new_upsert() *Upsert {
+	return &Upsert{
+		vars: make(map[string]string),
+		uids: make(map[string]string),
+	}
+}
+
+// match returns a uid reference to the node whose predicate equals value,
+// a new node is created by the mutation if none exists
+func (upsert *Upsert) match(predicate string, value string) string {
+	key := predicate + "=" + value
+	if uid, ok := upsert.uids[key]; ok {
+		return uid
+	}
+	return upsert.add(key, "var(func: eq("+predicate+", "+upsert.param(value)+"))")
+}
+
+// match_filtered is match with a second predicate the node must also equal
+func (upsert *Upsert) match_filtered(predicate string, value string, filter_predicate string, filter_value string) string {
+	key := predicate + "=" + value + "&" + filter_predicate + "=" + filter_value
+	if uid, ok := upsert.uids[key]; ok {
+		return uid
+	}
+	return upsert.add(key, "var(func: eq("+predicate+", "+upsert.param(value)+")) @filter(eq("+filter_predicate+", "+upsert.param(filter_value)+"))")
+}
+
+// linked returns a uid reference to the nodes the matched node links to
+// with edge, none if it doesn't exist
+func (upsert *Upsert) linked(predicate string, value string, edge string) string {
+	key := predicate + "=" + value + ">" + edge
+	if uid, ok := upsert.uids[key]; ok {
+		return uid
+	}
+	name := "n" + strconv.Itoa(len(upsert.blocks))
+	upsert.blocks = append(upsert.blocks, "var(func: eq("+predicate+", "+upsert.param(value)+")) { "+name+" as "+edge+" }")
+	upsert.uids[key] = "uid(" + name + ")"
+	return upsert.uids[key]
+}
+
+func (upsert *Upsert) add(key string, block string) string {
+	name := "n" + strconv.Itoa(len(upsert.blocks))
+	upsert.blocks = append(upsert.blocks, name+" as "+block)
+	upsert.uids[key] = "uid(" + name + ")"
+	return upsert.uids[key]
+}
+
+func (upsert *Upsert) param(value string) string {
+	param := "$v" + strconv.Itoa(len(upsert.params))
+	upsert.params = append(upsert.params, param+": string")
+	upsert.vars[param] = value
+	return param
+}
+
+// exists is a mutation condition on a uid reference matching one node
+func (upsert *Upsert) exists(uid string) string {
+	return "eq(len(" + strings.TrimSuffix(strings.TrimPrefix(uid, "uid("), ")") + "), 1)"
+}
+
+func (upsert *Upsert) query() string {
+	return "query upsert(" + strings.Join(upsert.params, ", ") + ") {\n\t" + strings.Join(upsert.blocks, "\n\t") + "\n}"
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/bits"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Near-duplicate detection config
+// Pages whose fingerprints differ in at most this many of their 64 bits are
+// near-duplicates. Must be below SIMHASH_BANDS
+const SIMHASH_THRESHOLD = 3
+
+// Words per shingle, the features hashed into a fingerprint
+const SIMHASH_SHINGLE_SIZE = 3
+
+// Shorter pages all look alike, they aren't fingerprinted
+const MIN_FINGERPRINT_WORDS = 30
+
+// Follow links found on a near-duplicate page, off to keep a cluster of tag
+// pages or print views from flooding the crawl
+const FOLLOW_DUPLICATE_LINKS = false
+
+// The fingerprint is split in this many bands for lookups. Two fingerprints
+// within SIMHASH_THRESHOLD bits share at least one band exactly
+const SIMHASH_BANDS = 4
+
+// Fails to compile unless SIMHASH_THRESHOLD < SIMHASH_BANDS, with more
+// differing bits than bands a near-duplicate could share no band
+var _ [SIMHASH_BANDS - SIMHASH_THRESHOLD - 1]struct{}
+
+// simhash fingerprints text so similar texts get fingerprints differing in
+// few bits. Returns false for texts too short to tell apart
+func simhash(text string) (uint64, bool) {
+	tokens := tokenize(text)
+	if len(tokens) < MIN_FINGERPRINT_WORDS {
+		return 0, false
+	}
+
+	var weights [64]int
+	for i := 0; i+SIMHASH_SHINGLE_SIZE <= len(tokens); i++ {
+		sum := fnv_hash(strings.Join(tokens[i:i+SIMHASH_SHINGLE_SIZE], " "))
+		for bit := 0; bit < 64; bit++ {
+			if sum&(1<<bit) != 0 {
+				weights[bit] += 1
+			} else {
+				weights[bit] -= 1
+			}
+		}
+	}
+
+	var fingerprint uint64
+	for bit, weight := range weights {
+		if weight > 0 {
+			fingerprint |= 1 << bit
+		}
+	}
+	return fingerprint, true
+}
+
+func fnv_hash(text string) uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(text))
+	return hash.Sum64()
+}
+
+func format_fingerprint(fingerprint uint64) string {
+	return fmt.Sprintf("%016x", fingerprint)
+}
+
+type Fingerprinted_page struct {
+	url         URL
+	fingerprint uint64
+	// The first page of the cluster this page belongs to, itself if none
+	original URL
+}
+
+// Duplicate_index holds the fingerprints of the crawled pages, bucketed by
+// band so a lookup only compares pages sharing a band
+type Duplicate_index struct {
+	lock  sync.Mutex
+	bands [SIMHASH_BANDS]map[uint64][]*Fingerprinted_page
+	count int
+}
+
+func new_duplicate_index() *Duplicate_index {
+	index := &Duplicate_index{}
+	for i := range index.bands {
+		index.bands[i] = make(map[uint64][]*Fingerprinted_page)
+	}
+	return index
+}
+
+func band(fingerprint uint64, i int) uint64 {
+	width := 64 / SIMHASH_BANDS
+	return (fingerprint >> (i * width)) & (1<<width - 1)
+}
+
+// check adds a fingerprinted page to the index and links it to the cluster
+// of the closest page within SIMHASH_THRESHOLD. Returns true if it's a
+// near-duplicate
+func (index *Duplicate_index) check(page *Page) bool {
+	fingerprint, err := strconv.ParseUint(page.Fingerprint, 16, 64)
+	if err != nil {
+		return false
+	}
+
+	index.lock.Lock()
+	defer index.lock.Unlock()
+
+	var closest *Fingerprinted_page
+	closest_distance := SIMHASH_THRESHOLD + 1
+	for i := range index.bands {
+		for _, candidate := range index.bands[i][band(fingerprint, i)] {
+			distance := bits.OnesCount64(fingerprint ^ candidate.fingerprint)
+			if distance < closest_distance && candidate.url != page.URL {
+				closest, closest_distance = candidate, distance
+			}
+		}
+	}
+
+	entry := &Fingerprinted_page{url: page.URL, fingerprint: fingerprint, original: page.URL}
+	if closest != nil {
+		entry.original = closest.original
+		page.Duplicate_of = &Page{URL: closest.original}
+		page.Duplicate_of.Duplicate_similarity = 1 - float64(closest_distance)/64
+		index.count += 1
+	}
+	for i := range index.bands {
+		key := band(fingerprint, i)
+		index.bands[i][key] = append(index.bands[i][key], entry)
+	}
+	return closest != nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"math/bits"
+	"strings"
+	"testing"
+)
+
+const test_text = `The crawler reads every page of the site, stores it in Dgraph with the
+links it found, and hands its text over to the analyzers. Pages that say
+almost the same thing as a page crawled before are marked as near
+duplicates of it, so print views and tag pages don't flood the results.
+Each page gets a fingerprint of sixty four bits built from the shingles of
+its words, three words at a time. Pages whose fingerprints differ in only a
+few bits share most of their shingles, and the index finds them by looking
+up the bands of the fingerprint rather than comparing every pair of pages.
+Links found on a duplicate aren't followed unless the config asks for it,
+the original page already brought them into the crawl queue anyway`
+
+func TestSimhash(t *testing.T) {
+	tests := []struct {
+		name          string
+		a             string
+		b             string
+		max_distance  int
+		min_distance  int
+		fingerprinted bool
+	}{
+		{"identical", test_text, test_text, 0, 0, true},
+		{"case and punctuation", test_text, strings.ToUpper(strings.ReplaceAll(test_text, ",", "")), 0, 0, true},
+		// A small edit moves a few bits, unrelated texts differ in about half
+		{"one word changed", test_text, strings.Replace(test_text, "flood", "swamp", 1), 8, 1, true},
+		{"different text", test_text, strings.Repeat("Nothing in common with the other page at all here ", 4), 48, 16, true},
+		{"too short", "A short page", "A short page", 0, 0, false},
+	}
+	for _, test := range tests {
+		a, ok_a := simhash(test.a)
+		b, ok_b := simhash(test.b)
+		if ok_a != test.fingerprinted || ok_b != test.fingerprinted {
+			t.Errorf("%s: fingerprinted %v and %v, want %v", test.name, ok_a, ok_b, test.fingerprinted)
+			continue
+		}
+		distance := bits.OnesCount64(a ^ b)
+		if distance > test.max_distance || distance < test.min_distance {
+			t.Errorf("%s: distance %d, want %d to %d", test.name, distance, test.min_distance, test.max_distance)
+		}
+	}
+}
+
+func TestDuplicateIndex(t *testing.T) {
+	const original = 0x0123456789abcdef
+	tests := []struct {
+		name        string
+		fingerprint uint64
+		duplicate   bool
+	}{
+		{"same fingerprint", original, true},
+		{"one bit in one band", original ^ 1, true},
+		// Three bands differ, the fourth still finds the original
+		{"threshold across bands", original ^ (1 | 1<<16 | 1<<32), true},
+		{"threshold in one band", original ^ 0b111, true},
+		{"one bit too many", original ^ (1 | 1<<16 | 1<<32 | 1<<48), false},
+		{"unrelated", ^uint64(original), false},
+	}
+	for _, test := range tests {
+		index := new_duplicate_index()
+		index.check(&Page{URL: "https://example.com/", Fingerprint: format_fingerprint(original)})
+
+		page := &Page{URL: "https://example.com/copy", Fingerprint: format_fingerprint(test.fingerprint)}
+		if got := index.check(page); got != test.duplicate {
+			t.Errorf("%s: duplicate %v, want %v", test.name, got, test.duplicate)
+			continue
+		}
+		if test.duplicate && page.Duplicate_of.URL != "https://example.com/" {
+			t.Errorf("%s: duplicate of %s", test.name, page.Duplicate_of.URL)
+		}
+	}
+}
+
+func TestBand(t *testing.T) {
+	fingerprint := uint64(0x0123456789abcdef)
+	want := []uint64{0xcdef, 0x89ab, 0x4567, 0x0123}
+	for i := range want {
+		if got := band(fingerprint, i); got != want[i] {
+			t.Errorf("band %d = %x, want %x", i, got, want[i])
+		}
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/charmbracelet/log"
+)
+
+const ANALYZER_ENTITIES = "entities" // Rule and gazetteer based named entities, in Go
+
+// Kinds of named entity
+const ENTITY_PERSON = "person"
+const ENTITY_ORGANIZATION = "organization"
+const ENTITY_PLACE = "place"
+const ENTITY_PRODUCT = "product"
+
+// Entity analyzer config
+const ENTITY_MAX_PER_PAGE = 20
+
+// Extra gazetteer entries, one "<kind>\t<name>" per line, empty for none
+const ENTITY_GAZETTEER_FILE = ""
+
+// A Named_entity is stored once per kind and name, linked from the pages
+// mentioning it
+type Named_entity struct {
+	UID   string   `json:"uid,omitempty"`
+	Key   string   `json:"named_entity_key,omitempty"`
+	Name  string   `json:"named_entity_name,omitempty"`
+	Kind  string   `json:"named_entity_kind,omitempty"`
+	DType []string `json:"dgraph.type,omitempty"`
+
+	// Facet of the mentions edge, how often the page mentions the entity
+	Mention_count int `json:"mentions|count,omitempty"`
+}
+
+// named_entity_key identifies an entity across pages and domains
+func named_entity_key(kind string, name string) string {
+	return kind + ":" + strings.ToLower(name)
+}
+
+// Known entities, by lowercase name. Extended by ENTITY_GAZETTEER_FILE
+var ENTITY_GAZETTEER = map[string]string{
+	"google": ENTITY_ORGANIZATION, "microsoft": ENTITY_ORGANIZATION, "apple": ENTITY_ORGANIZATION,
+	"amazon": ENTITY_ORGANIZATION, "meta": ENTITY_ORGANIZATION, "openai": ENTITY_ORGANIZATION,
+	"united nations": ENTITY_ORGANIZATION, "european union": ENTITY_ORGANIZATION, "nasa": ENTITY_ORGANIZATION,
+	"wikipedia": ENTITY_ORGANIZATION, "github": ENTITY_ORGANIZATION, "mozilla": ENTITY_ORGANIZATION,
+	"saudi arabia": ENTITY_PLACE, "riyadh": ENTITY_PLACE, "jeddah": ENTITY_PLACE, "egypt": ENTITY_PLACE,
+	"cairo": ENTITY_PLACE, "united states": ENTITY_PLACE, "london": ENTITY_PLACE, "paris": ENTITY_PLACE,
+	"berlin": ENTITY_PLACE, "madrid": ENTITY_PLACE, "new york": ENTITY_PLACE, "europe": ENTITY_PLACE,
+	"africa": ENTITY_PLACE, "asia": ENTITY_PLACE, "germany": ENTITY_PLACE, "france": ENTITY_PLACE,
+	"spain": ENTITY_PLACE, "china": ENTITY_PLACE, "japan": ENTITY_PLACE, "india": ENTITY_PLACE,
+	"السعودية": ENTITY_PLACE, "الرياض": ENTITY_PLACE, "جدة": ENTITY_PLACE, "مصر": ENTITY_PLACE,
+	"القاهرة": ENTITY_PLACE, "الأمم المتحدة": ENTITY_ORGANIZATION,
+	"iphone": ENTITY_PRODUCT, "android": ENTITY_PRODUCT, "windows": ENTITY_PRODUCT, "linux": ENTITY_PRODUCT,
+	"chrome": ENTITY_PRODUCT, "firefox": ENTITY_PRODUCT, "dgraph": ENTITY_PRODUCT, "chatgpt": ENTITY_PRODUCT,
+}
+
+// Words before a name that make it a person
+var PERSON_TITLES = make_set(strings.Fields(`
+	mr mrs ms miss dr prof professor sir dame president minister senator king queen prince princess ceo
+	founder director author sheikh
+`))
+
+// Last words of organization names, or first words as in "University of ..."
+var ORGANIZATION_WORDS = make_set(strings.Fields(`
+	inc ltd llc corp corporation company co group foundation institute university college school bank
+	association agency ministry council committee society union party team club labs gmbh sa plc
+`))
+
+// Last words of place names, or first words as in "Republic of ..."
+var PLACE_WORDS = make_set(strings.Fields(`
+	city county state province region street road avenue river lake sea ocean island islands mountain
+	mountains valley bay desert republic kingdom emirates
+`))
+
+// Capitalised words that aren't names on their own
+var NOT_NAMES = make_set(strings.Fields(`
+	the a an this that these those it its i we you he she they our your my his her their
+	monday tuesday wednesday thursday friday saturday sunday january february march april may june july
+	august september october november december home about contact menu search login read more next
+	previous page news blog share
+`))
+
+// Lowercase words allowed inside a name, as in "Bank of England"
+var NAME_CONNECTORS = make_set([]string{"of", "de", "la", "del", "von", "van", "al", "bin", "and", "&"})
+
+// Entity_analyzer finds named entities with the gazetteer and a few rules
+// over capitalised words. It only knows capitalisation for languages that
+// have it, other languages get gazetteer matches only
+type Entity_analyzer struct {
+	gazetteer map[string]string
+}
+
+func new_entity_analyzer() *Entity_analyzer {
+	gazetteer := make(map[string]string)
+	for name, kind := range ENTITY_GAZETTEER {
+		gazetteer[name] = kind
+	}
+	if ENTITY_GAZETTEER_FILE != "" {
+		if err := load_gazetteer(ENTITY_GAZETTEER_FILE, gazetteer); err != nil {
+			log.Warn("could not load gazetteer", "file", ENTITY_GAZETTEER_FILE, "err", err)
+		}
+	}
+	return &Entity_analyzer{gazetteer: gazetteer}
+}
+
+func load_gazetteer(path string, gazetteer map[string]string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		kind, name, ok := strings.Cut(scanner.Text(), "\t")
+		if ok && strings.TrimSpace(name) != "" {
+			gazetteer[strings.ToLower(strings.TrimSpace(name))] = strings.TrimSpace(kind)
+		}
+	}
+	return scanner.Err()
+}
+
+func (analyzer *Entity_analyzer) name() string {
+	return ANALYZER_ENTITIES
+}
+
+func (analyzer *Entity_analyzer) version() string {
+	return "3"
+}
+
+func (analyzer *Entity_analyzer) analyze(document Document) (Annotations, error) {
+	counts := make(map[string]*Named_entity)
+	add := func(kind string, name string) {
+		key := named_entity_key(kind, name)
+		if counts[key] == nil {
+			counts[key] = &Named_entity{Key: key, Name: name, Kind: kind}
+		}
+		counts[key].Mention_count += 1
+	}
+
+	language := document_language(document, tokenize(document.Text))
+	// Lines rather than sentences, so "Dr. Sarah Connor" stays in one piece.
+	// Punctuation after a word ends a name anyway
+	for _, line := range strings.Split(document.Text, "\n") {
+		words := strings.Fields(line)
+		for start := 0; start < len(words); {
+			name, length := analyzer.gazetteer_match(words[start:])
+			if length > 0 {
+				add(analyzer.gazetteer[strings.ToLower(name)], name)
+				start += length
+				continue
+			}
+			name, kind, length := analyzer.classify_name(words, start, language)
+			if length == 0 {
+				start += 1
+				continue
+			}
+			if kind != "" {
+				add(kind, name)
+			}
+			start += length
+		}
+	}
+
+	entities := []Named_entity{}
+	for _, entity := range counts {
+		entities = append(entities, *entity)
+	}
+	sort.Slice(entities, func(i, j int) bool {
+		if entities[i].Mention_count != entities[j].Mention_count {
+			return entities[i].Mention_count > entities[j].Mention_count
+		}
+		return entities[i].Key < entities[j].Key
+	})
+	if len(entities) > ENTITY_MAX_PER_PAGE {
+		entities = entities[:ENTITY_MAX_PER_PAGE]
+	}
+	return Annotations{Entities: entities}, nil
+}
+
+// gazetteer_match finds the longest gazetteer name, up to four words, at
+// the start of words. Returns the name as written and its length in words.
+// Names must be written capitalised, "windows" and "apple pie" aren't
+// products or companies
+func (analyzer *Entity_analyzer) gazetteer_match(words []string) (string, int) {
+	parts := []string{}
+	for i := 0; i < len(words) && i < 4; i++ {
+		// Punctuation after a word ends the name
+		if i > 0 && ends_with_punctuation(words[i-1]) {
+			break
+		}
+		word := trim_word(words[i])
+		if !has_capital(word) && !(i > 0 && NAME_CONNECTORS[word]) {
+			break
+		}
+		parts = append(parts, word)
+	}
+	for length := len(parts); length > 0; length-- {
+		name := strings.Join(parts[:length], " ")
+		if _, ok := analyzer.gazetteer[strings.ToLower(name)]; ok {
+			return name, length
+		}
+	}
+	return "", 0
+}
+
+// classify_name reads the run of capitalised words at start and guesses
+// its kind from the words around it. An empty kind means a name of unknown
+// kind, a zero length no name at all
+func (analyzer *Entity_analyzer) classify_name(words []string, start int, language string) (string, string, int) {
+	parts := []string{}
+	end := start
+	for end < len(words) {
+		word := trim_word(words[end])
+		lower := strings.ToLower(word)
+		is_name := is_capitalised(word) && !NOT_NAMES[lower] && !is_stopword(lower, language)
+		is_connector := len(parts) > 0 && NAME_CONNECTORS[word] && end+1 < len(words) && is_capitalised(trim_word(words[end+1]))
+		if !is_name && !is_connector {
+			break
+		}
+		// A known name ends the run, "Yesterday Google announced" is about
+		// Google. Unless the run names one, as in "University of London"
+		if len(parts) > 0 && !is_name_word(parts[0]) {
+			if _, length := analyzer.gazetteer_match(words[end:]); length > 0 {
+				break
+			}
+		}
+		parts = append(parts, word)
+		end += 1
+		if ends_with_punctuation(words[end-1]) {
+			break
+		}
+	}
+	for len(parts) > 0 && NAME_CONNECTORS[parts[len(parts)-1]] {
+		parts = parts[:len(parts)-1]
+		end -= 1
+	}
+	if len(parts) == 0 {
+		return "", "", 0
+	}
+
+	// An organization or place after a connector is a name of its own,
+	// "Alan Turing of Cambridge University" is two names and "Bank of
+	// England" one
+	if !is_name_word(parts[0]) {
+		for i, part := range parts {
+			if !NAME_CONNECTORS[part] || part == "and" || part == "&" {
+				continue
+			}
+			if rest := parts[i+1:]; len(rest) > 1 && is_name_word(rest[len(rest)-1]) {
+				parts = parts[:i]
+				end = start + i
+			}
+			break
+		}
+	}
+	name := strings.Join(parts, " ")
+	length := end - start
+
+	previous := ""
+	if start > 0 {
+		previous = strings.ToLower(trim_word(words[start-1]))
+	}
+	next := ""
+	if end < len(words) && !ends_with_punctuation(words[end-1]) {
+		next = trim_word(words[end])
+	}
+	first := strings.ToLower(parts[0])
+	last := strings.ToLower(parts[len(parts)-1])
+
+	// A person's name ends before a connector, "Dr Alan Turing of
+	// Cambridge" leaves the rest to be read again
+	person := parts
+	for i, part := range parts {
+		if NAME_CONNECTORS[part] {
+			person = parts[:i]
+			break
+		}
+	}
+
+	switch {
+	case PERSON_TITLES[first] && len(person) > 1:
+		return strings.Join(person[1:], " "), ENTITY_PERSON, len(person)
+	case PERSON_TITLES[previous]:
+		return strings.Join(person, " "), ENTITY_PERSON, len(person)
+	case ORGANIZATION_WORDS[last] && len(parts) > 1, ORGANIZATION_WORDS[first] && len(parts) > 2:
+		return name, ENTITY_ORGANIZATION, length
+	case PLACE_WORDS[last] && len(parts) > 1, PLACE_WORDS[first] && len(parts) > 2:
+		return name, ENTITY_PLACE, length
+	case is_version(next) && len(parts) == 1:
+		return name + " " + next, ENTITY_PRODUCT, length + 1
+	case (previous == "in" || previous == "from" || previous == "near") && len(parts) <= 2:
+		return name, ENTITY_PLACE, length
+	}
+	return name, "", length
+}
+
+// is_name_word is true for words that make a name an organization or a
+// place, like "University" or "Republic"
+func is_name_word(word string) bool {
+	lower := strings.ToLower(word)
+	return ORGANIZATION_WORDS[lower] || PLACE_WORDS[lower]
+}
+
+func ends_with_punctuation(word string) bool {
+	return strings.TrimRight(word, ".,;:!?)\"'") != word
+}
+
+// trim_word strips the punctuation around a word
+func trim_word(word string) string {
+	return strings.TrimFunc(word, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '&'
+	})
+}
+
+func is_capitalised(word string) bool {
+	for _, r := range word {
+		return unicode.IsUpper(r)
+	}
+	return false
+}
+
+// has_capital is true for words with an uppercase letter, as in "iPhone",
+// and for words of scripts without case
+func has_capital(word string) bool {
+	lowercase := false
+	for _, r := range word {
+		if unicode.IsUpper(r) {
+			return true
+		}
+		lowercase = lowercase || unicode.IsLower(r)
+	}
+	return word != "" && !lowercase
+}
+
+// is_version matches product versions like "15", "3.2" or "X"
+func is_version(word string) bool {
+	if word == "" {
+		return false
+	}
+	if word == "X" || word == "Pro" || word == "Max" {
+		return true
+	}
+	for _, r := range word {
+		if !unicode.IsDigit(r) && r != '.' {
+			return false
+		}
+	}
+	return true
+}
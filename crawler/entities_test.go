@@ -0,0 +1,88 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestEntityAnalyzer(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Yesterday Google announced a new phone.", []string{"organization:Google"}},
+		{"Alan Turing of Cambridge University wrote it.", []string{"organization:Cambridge University"}},
+		{"She spoke to Dr Alan Turing of Cambridge about it.", []string{"person:Alan Turing"}},
+		{"The Bank of England raised rates.", []string{"organization:Bank of England"}},
+		{"He studied at the University of London last year.", []string{"organization:University of London"}},
+		{"Alan Turing of Google spoke.", []string{"organization:Google"}},
+		{"Prices rose in Springfield last week.", []string{"place:Springfield"}},
+		{"He moved to the Red River Valley.", []string{"place:Red River Valley"}},
+		{"Everyone bought an iPhone 15 and Windows laptops.", []string{"product:Windows", "product:iPhone"}},
+		{"Mr Smith met the board of Acme Corp.", []string{"organization:Acme Corp", "person:Smith"}},
+		{"We ate apple pie and ran windows updates.", []string{}},
+	}
+	analyzer := new_entity_analyzer()
+	for _, test := range tests {
+		annotations, err := analyzer.analyze(Document{Text: test.text, Language: LANGUAGE_ENGLISH})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		for _, entity := range annotations.Entities {
+			got = append(got, entity.Kind+":"+entity.Name)
+		}
+		sort.Strings(got)
+		if strings.Join(got, ", ") != strings.Join(test.want, ", ") {
+			t.Errorf("%q: got %v, want %v", test.text, got, test.want)
+		}
+	}
+}
+
+func TestEntityMentionCounts(t *testing.T) {
+	analyzer := new_entity_analyzer()
+	annotations, _ := analyzer.analyze(Document{Text: "Google and Microsoft.\nGoogle again.", Language: LANGUAGE_ENGLISH})
+	if len(annotations.Entities) != 2 || annotations.Entities[0].Name != "Google" || annotations.Entities[0].Mention_count != 2 {
+		t.Errorf("got %+v, want Google first with 2 mentions", annotations.Entities)
+	}
+	if annotations.Entities[0].Key != named_entity_key(ENTITY_ORGANIZATION, "google") {
+		t.Errorf("key %q", annotations.Entities[0].Key)
+	}
+}
+
+func TestEntityAnalyzerArabic(t *testing.T) {
+	analyzer := new_entity_analyzer()
+	annotations, _ := analyzer.analyze(Document{Text: "زار الوزير الرياض ثم القاهرة", Language: LANGUAGE_ARABIC})
+	got := []string{}
+	for _, entity := range annotations.Entities {
+		got = append(got, entity.Name)
+	}
+	sort.Strings(got)
+	if strings.Join(got, ",") != "الرياض,القاهرة" {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestClassifyName(t *testing.T) {
+	tests := []struct {
+		text   string
+		name   string
+		kind   string
+		length int
+	}{
+		{"Alan Turing of Cambridge University", "Alan Turing", "", 2},
+		{"Yesterday Google announced", "Yesterday", "", 1},
+		{"Bank of England", "Bank of England", ENTITY_ORGANIZATION, 3},
+		{"Kingdom of Saudi Arabia", "Kingdom of Saudi Arabia", ENTITY_PLACE, 4},
+		{"Chrome 120 is out", "Chrome 120", ENTITY_PRODUCT, 2},
+		{"the end", "", "", 0},
+	}
+	analyzer := new_entity_analyzer()
+	for _, test := range tests {
+		name, kind, length := analyzer.classify_name(strings.Fields(test.text), 0, LANGUAGE_ENGLISH)
+		if name != test.name || kind != test.kind || length != test.length {
+			t.Errorf("classify_name(%q) = %q, %q, %d, want %q, %q, %d", test.text, name, kind, length, test.name, test.kind, test.length)
+		}
+	}
+}
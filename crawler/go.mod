@@ -2,7 +2,7 @@ module gopher-crawler
 
 go 1.20
 
-require golang.org/x/net v0.14.0 // indirect
+require golang.org/x/net v0.14.0
 
 require (
 	github.com/PuerkitoBio/goquery v1.8.1
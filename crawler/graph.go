@@ -0,0 +1,267 @@
+package main
+
+import (
+	"math"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/charmbracelet/log"
+	"github.com/dgraph-io/dgo/v2"
+	"github.com/jedib0t/go-pretty/v6/table"
+)
+
+// Link graph config
+const PAGERANK_DAMPING = 0.85
+const PAGERANK_ITERATIONS = 100
+const PAGERANK_TOLERANCE = 1e-9
+const HITS_ITERATIONS = 100
+
+// A Link_graph is the related_pages graph of a crawl, pages are numbered
+// and each has the pages it links to and the pages linking to it
+type Link_graph struct {
+	urls  []URL
+	nodes map[URL]int
+	out   [][]int
+	in    [][]int
+	edges map[[2]int]bool
+}
+
+func new_link_graph() *Link_graph {
+	return &Link_graph{
+		nodes: make(map[URL]int),
+		edges: make(map[[2]int]bool),
+	}
+}
+
+func (graph *Link_graph) node(url URL) int {
+	if i, ok := graph.nodes[url]; ok {
+		return i
+	}
+	graph.nodes[url] = len(graph.urls)
+	graph.urls = append(graph.urls, url)
+	graph.out = append(graph.out, nil)
+	graph.in = append(graph.in, nil)
+	return graph.nodes[url]
+}
+
+// add_edge links two pages once. Links to self and links to pages that
+// aren't nodes, on other sites or never crawled, are dropped
+func (graph *Link_graph) add_edge(from URL, to URL) {
+	i, from_ok := graph.nodes[from]
+	j, to_ok := graph.nodes[to]
+	if !from_ok || !to_ok || i == j || graph.edges[[2]int{i, j}] {
+		return
+	}
+	graph.edges[[2]int{i, j}] = true
+	graph.out[i] = append(graph.out[i], j)
+	graph.in[j] = append(graph.in[j], i)
+}
+
+// graph_from_index builds the link graph of the crawl in memory, between
+// the crawled pages of the seed's site. Only links the spiders follow
+// count, aliases are resolved to their canonical page
+func graph_from_index(index *Index) *Link_graph {
+	graph := new_link_graph()
+	index.lock.Lock()
+	defer index.lock.Unlock()
+
+	// Map order would number pages differently on each call
+	urls := []URL{}
+	for url, page := range index.inprogress_or_done_pages {
+		if page.URL == url && page.Is_crawled && same_site(url, index.crawl.Seed) {
+			urls = append(urls, url)
+		}
+	}
+	sort.Strings(urls)
+	for _, url := range urls {
+		graph.node(url)
+	}
+
+	for _, url := range urls {
+		page := index.inprogress_or_done_pages[url]
+		targets := []URL{}
+		for target, link := range page.related_pages {
+			if CRAWLED_LINK_KINDS[link.Link_kind] {
+				if canonical, ok := index.inprogress_or_done_pages[target]; ok {
+					target = canonical.URL
+				}
+				targets = append(targets, target)
+			}
+		}
+		sort.Strings(targets)
+		for _, target := range targets {
+			graph.add_edge(url, target)
+		}
+	}
+	return graph
+}
+
+// ## Scores
+
+// Link_scores are stored on each Page by Db_set_link_scores
+type Link_scores struct {
+	Pagerank        float64 `json:"pagerank,omitempty"`
+	Hub_score       float64 `json:"hub_score,omitempty"`
+	Authority_score float64 `json:"authority_score,omitempty"`
+	In_degree       int     `json:"in_degree,omitempty"`
+	Out_degree      int     `json:"out_degree,omitempty"`
+}
+
+func link_scores(graph *Link_graph) []Link_scores {
+	ranks := pagerank(graph, PAGERANK_DAMPING)
+	hubs, authorities := hits(graph)
+	scores := make([]Link_scores, len(graph.urls))
+	for i := range graph.urls {
+		scores[i] = Link_scores{
+			Pagerank:        ranks[i],
+			Hub_score:       hubs[i],
+			Authority_score: authorities[i],
+			In_degree:       len(graph.in[i]),
+			Out_degree:      len(graph.out[i]),
+		}
+	}
+	return scores
+}
+
+// pagerank by power iteration. Pages without out-links spread their rank
+// over every page, so ranks keep summing to 1
+func pagerank(graph *Link_graph, damping float64) []float64 {
+	n := len(graph.urls)
+	ranks := make([]float64, n)
+	if n == 0 {
+		return ranks
+	}
+	for i := range ranks {
+		ranks[i] = 1 / float64(n)
+	}
+
+	for iteration := 0; iteration < PAGERANK_ITERATIONS; iteration++ {
+		dangling := 0.0
+		for i := range ranks {
+			if len(graph.out[i]) == 0 {
+				dangling += ranks[i]
+			}
+		}
+
+		next := make([]float64, n)
+		for i := range next {
+			next[i] = (1-damping)/float64(n) + damping*dangling/float64(n)
+		}
+		for i, targets := range graph.out {
+			for _, j := range targets {
+				next[j] += damping * ranks[i] / float64(len(targets))
+			}
+		}
+
+		change := 0.0
+		for i := range ranks {
+			change += math.Abs(next[i] - ranks[i])
+		}
+		ranks = next
+		if change < PAGERANK_TOLERANCE {
+			break
+		}
+	}
+	return ranks
+}
+
+// hits returns the hub and authority scores of each page, normalized so
+// their squares sum to 1
+func hits(graph *Link_graph) ([]float64, []float64) {
+	n := len(graph.urls)
+	hubs := make([]float64, n)
+	authorities := make([]float64, n)
+	for i := range hubs {
+		hubs[i] = 1
+		authorities[i] = 1
+	}
+
+	for iteration := 0; iteration < HITS_ITERATIONS; iteration++ {
+		for i := range authorities {
+			authorities[i] = 0
+			for _, j := range graph.in[i] {
+				authorities[i] += hubs[j]
+			}
+		}
+		normalize_scores(authorities)
+		for i := range hubs {
+			hubs[i] = 0
+			for _, j := range graph.out[i] {
+				hubs[i] += authorities[j]
+			}
+		}
+		normalize_scores(hubs)
+	}
+	return hubs, authorities
+}
+
+func normalize_scores(scores []float64) {
+	norm := 0.0
+	for _, score := range scores {
+		norm += score * score
+	}
+	norm = math.Sqrt(norm)
+	if norm == 0 {
+		return
+	}
+	for i := range scores {
+		scores[i] /= norm
+	}
+}
+
+// rank_pages scores the pages of the crawl and stores the scores, on the
+// pages in memory too so they can be displayed
+func rank_pages(index *Index, dg *dgo.Dgraph) {
+	graph := graph_from_index(index)
+	scores := link_scores(graph)
+
+	index.lock.Lock()
+	for i, url := range graph.urls {
+		if page, ok := index.inprogress_or_done_pages[url]; ok && page.URL == url {
+			page.Link_scores = scores[i]
+		}
+	}
+	index.lock.Unlock()
+
+	Db_set_link_scores(dg, graph.urls, scores)
+	log.Info("Ranked pages", "pages", len(graph.urls), "links", len(graph.edges))
+}
+
+// rank_command scores the pages of a site again from the stored graph, with
+// every crawl of it
+func rank_command(args []string) {
+	if len(args) != 1 {
+		log.Fatal(USAGE)
+	}
+	domain, err := domain_name(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	dg := Db_connect()
+	graph := Db_load_link_graph(dg, domain)
+	scores := link_scores(graph)
+	Db_set_link_scores(dg, graph.urls, scores)
+	log.Info("Ranked pages", "domain", domain, "pages", len(graph.urls), "links", len(graph.edges))
+
+	order := make([]int, len(graph.urls))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool {
+		return scores[order[a]].Pagerank > scores[order[b]].Pagerank
+	})
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"URL", "PageRank", "Hub", "Authority", "In", "Out"})
+	for _, i := range order {
+		t.AppendRow(table.Row{graph.urls[i], format_score(scores[i].Pagerank), format_score(scores[i].Hub_score), format_score(scores[i].Authority_score), scores[i].In_degree, scores[i].Out_degree})
+	}
+	t.SetTitle("Ranked pages of " + domain)
+	t.Render()
+}
+
+func format_score(score float64) string {
+	return strconv.FormatFloat(score, 'f', 4, 64)
+}
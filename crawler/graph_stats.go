@@ -0,0 +1,459 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/charmbracelet/log"
+	"github.com/jedib0t/go-pretty/v6/table"
+)
+
+// Graph stats config
+// Pages more clicks than this away from the seed are reported as buried
+const STATS_BURIED_DEPTH = 4
+
+const LABEL_PROPAGATION_ITERATIONS = 20
+
+// Shortest paths between pages are searched from this many pages at most,
+// picked at random on larger sites. Searching from every page takes time
+// growing with pages times links
+const STATS_PATH_SOURCES = 500
+
+// Pages listed per section of the report
+const STATS_LIST_LIMIT = 10
+
+// Graph_stats are the structure of a link graph. Components and
+// communities are numbered by size, largest first
+type Graph_stats struct {
+	component      []int
+	component_size []int
+	community      []int
+	community_size []int
+	// Clicks from the seed and the page before on a shortest path, -1 if
+	// the seed doesn't reach the page
+	click_depth  []int
+	click_parent []int
+	// Longest shortest path between two pages, and how many pairs of
+	// pages are each number of clicks apart, from path_sources pages
+	diameter        int
+	path_histogram  map[int]int
+	path_sources    int
+	depth_histogram map[int]int
+}
+
+func graph_stats(graph *Link_graph, seed int) Graph_stats {
+	stats := Graph_stats{}
+	stats.component, stats.component_size = relabel_by_size(strongly_connected_components(graph))
+	stats.community, stats.community_size = relabel_by_size(label_propagation(graph))
+
+	stats.click_depth, stats.click_parent = shortest_paths(graph, seed)
+	stats.depth_histogram = make(map[int]int)
+	for _, depth := range stats.click_depth {
+		stats.depth_histogram[depth] += 1
+	}
+
+	stats.path_histogram = make(map[int]int)
+	sources := path_sources(len(graph.urls), STATS_PATH_SOURCES)
+	stats.path_sources = len(sources)
+	for _, i := range sources {
+		distances, _ := shortest_paths(graph, i)
+		for j, distance := range distances {
+			if j == i || distance < 0 {
+				continue
+			}
+			stats.path_histogram[distance] += 1
+			if distance > stats.diameter {
+				stats.diameter = distance
+			}
+		}
+	}
+	return stats
+}
+
+// path_sources picks the pages to search shortest paths from, every page
+// when there are at most limit, else limit of them at random, seeded so
+// the same graph gives the same stats
+func path_sources(pages int, limit int) []int {
+	if pages <= limit {
+		sources := make([]int, pages)
+		for i := range sources {
+			sources[i] = i
+		}
+		return sources
+	}
+	sources := rand.New(rand.NewSource(1)).Perm(pages)[:limit]
+	sort.Ints(sources)
+	return sources
+}
+
+// strongly_connected_components with Tarjan's algorithm, iterative so deep
+// sites don't overflow the stack. Returns a component id per page
+func strongly_connected_components(graph *Link_graph) []int {
+	n := len(graph.urls)
+	order := make([]int, n)
+	lowlink := make([]int, n)
+	on_stack := make([]bool, n)
+	component := make([]int, n)
+	for i := range order {
+		order[i] = -1
+	}
+
+	stack := []int{}
+	counter, components := 0, 0
+	type frame struct{ node, next int }
+	for root := 0; root < n; root++ {
+		if order[root] >= 0 {
+			continue
+		}
+		calls := []frame{{root, 0}}
+		order[root], lowlink[root] = counter, counter
+		counter += 1
+		stack = append(stack, root)
+		on_stack[root] = true
+
+		for len(calls) > 0 {
+			top := &calls[len(calls)-1]
+			node := top.node
+			if top.next < len(graph.out[node]) {
+				target := graph.out[node][top.next]
+				top.next += 1
+				if order[target] < 0 {
+					order[target], lowlink[target] = counter, counter
+					counter += 1
+					stack = append(stack, target)
+					on_stack[target] = true
+					calls = append(calls, frame{target, 0})
+				} else if on_stack[target] && order[target] < lowlink[node] {
+					lowlink[node] = order[target]
+				}
+				continue
+			}
+
+			// Every link of node is done, pop it
+			calls = calls[:len(calls)-1]
+			if len(calls) > 0 {
+				parent := calls[len(calls)-1].node
+				if lowlink[node] < lowlink[parent] {
+					lowlink[parent] = lowlink[node]
+				}
+			}
+			if lowlink[node] == order[node] {
+				for {
+					member := stack[len(stack)-1]
+					stack = stack[:len(stack)-1]
+					on_stack[member] = false
+					component[member] = components
+					if member == node {
+						break
+					}
+				}
+				components += 1
+			}
+		}
+	}
+	return component
+}
+
+// label_propagation finds communities: each page takes the label most of
+// its neighbours have, links counted both ways, until labels settle. Pages
+// are visited in a new random order each round and ties are broken at
+// random, a fixed order lets one label flood the graph. A page keeps its
+// label when it's among the ties. The seed is fixed so runs are repeatable
+func label_propagation(graph *Link_graph) []int {
+	random := rand.New(rand.NewSource(1))
+	labels := make([]int, len(graph.urls))
+	order := make([]int, len(graph.urls))
+	for i := range labels {
+		labels[i] = i
+		order[i] = i
+	}
+
+	for iteration := 0; iteration < LABEL_PROPAGATION_ITERATIONS; iteration++ {
+		changed := false
+		random.Shuffle(len(order), func(i, j int) {
+			order[i], order[j] = order[j], order[i]
+		})
+		for _, i := range order {
+			counts := make(map[int]int)
+			for _, j := range graph.out[i] {
+				counts[labels[j]] += 1
+			}
+			for _, j := range graph.in[i] {
+				counts[labels[j]] += 1
+			}
+			if len(counts) == 0 {
+				continue
+			}
+
+			best_count := 0
+			for _, count := range counts {
+				if count > best_count {
+					best_count = count
+				}
+			}
+			if counts[labels[i]] == best_count {
+				continue
+			}
+			// Sorted so the pick only depends on the seed, not on map order
+			ties := []int{}
+			for label, count := range counts {
+				if count == best_count {
+					ties = append(ties, label)
+				}
+			}
+			sort.Ints(ties)
+			labels[i] = ties[random.Intn(len(ties))]
+			changed = true
+		}
+		if !changed {
+			break
+		}
+	}
+	return labels
+}
+
+// relabel_by_size numbers groups from 0, largest first, and returns the
+// size of each group
+func relabel_by_size(groups []int) ([]int, []int) {
+	sizes := make(map[int]int)
+	for _, group := range groups {
+		sizes[group] += 1
+	}
+	order := []int{}
+	for group := range sizes {
+		order = append(order, group)
+	}
+	sort.Slice(order, func(i, j int) bool {
+		if sizes[order[i]] != sizes[order[j]] {
+			return sizes[order[i]] > sizes[order[j]]
+		}
+		return order[i] < order[j]
+	})
+
+	number := make(map[int]int)
+	numbered_sizes := make([]int, len(order))
+	for i, group := range order {
+		number[group] = i
+		numbered_sizes[i] = sizes[group]
+	}
+	relabeled := make([]int, len(groups))
+	for i, group := range groups {
+		relabeled[i] = number[group]
+	}
+	return relabeled, numbered_sizes
+}
+
+// shortest_paths is a breadth first search from a page along links. Returns
+// the clicks to each page and the page before it, -1 where unreachable
+func shortest_paths(graph *Link_graph, from int) ([]int, []int) {
+	distances := make([]int, len(graph.urls))
+	parents := make([]int, len(graph.urls))
+	for i := range distances {
+		distances[i], parents[i] = -1, -1
+	}
+	if from < 0 {
+		return distances, parents
+	}
+
+	distances[from] = 0
+	queue := []int{from}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		for _, target := range graph.out[node] {
+			if distances[target] < 0 {
+				distances[target] = distances[node] + 1
+				parents[target] = node
+				queue = append(queue, target)
+			}
+		}
+	}
+	return distances, parents
+}
+
+// click_path follows the parents back from a page to the seed
+func (stats *Graph_stats) click_path(graph *Link_graph, page int) []URL {
+	path := []URL{}
+	for node := page; node >= 0; node = stats.click_parent[node] {
+		path = append([]URL{graph.urls[node]}, path...)
+	}
+	return path
+}
+
+// stats_command reports the structure of a site from the stored graph of
+// every crawl of it, and stores each page's share of it
+func stats_command(args []string) {
+	if len(args) != 1 {
+		log.Fatal(USAGE)
+	}
+	domain, err := domain_name(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	dg := Db_connect()
+	graph := Db_load_link_graph(dg, domain)
+	if len(graph.urls) == 0 {
+		log.Fatal("no crawled pages stored", "domain", domain)
+	}
+
+	seed := seed_node(graph, args[0])
+	if seed < 0 {
+		log.Warn("seed isn't a crawled page, click depths are left out", "seed", args[0])
+	}
+
+	stats := graph_stats(graph, seed)
+	Db_set_graph_stats(dg, graph, stats)
+	display_graph_stats(graph, stats, domain)
+}
+
+// seed_node finds the target of a crawl in the graph, -1 if it wasn't crawled
+func seed_node(graph *Link_graph, target_url string) int {
+	if seed, ok := graph.nodes[new_crawl(target_url).Seed]; ok {
+		return seed
+	}
+	if seed, ok := graph.nodes[target_url]; ok {
+		return seed
+	}
+	return -1
+}
+
+func display_graph_stats(graph *Link_graph, stats Graph_stats, domain string) {
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendRows([]table.Row{
+		{"Pages", len(graph.urls)},
+		{"Links", len(graph.edges)},
+		{"Strongly connected components", len(stats.component_size)},
+		{"Largest component", stats.component_size[0]},
+		{"Communities", len(stats.community_size)},
+		{"Diameter", diameter_label(graph, stats)},
+		{"Unreachable from the seed", stats.depth_histogram[-1]},
+	})
+	t.SetTitle("Link graph of " + domain)
+	t.Render()
+
+	display_histogram("Click depth from the seed", stats.depth_histogram, "Clicks")
+	display_histogram("Shortest paths between pages", stats.path_histogram, "Clicks")
+
+	sizes := table.NewWriter()
+	sizes.SetOutputMirror(os.Stdout)
+	sizes.AppendHeader(table.Row{"Community", "Pages", "Examples"})
+	for community, size := range stats.community_size {
+		if community == STATS_LIST_LIMIT {
+			break
+		}
+		sizes.AppendRow(table.Row{community, size, fmt.Sprint(pages_where(graph, stats.community, community, 3))})
+	}
+	sizes.SetTitle("Largest communities")
+	sizes.Render()
+
+	// Sections of several pages the seed can't reach, or that link nowhere
+	// outside themselves so visitors can't get back out
+	leaves := make([]bool, len(stats.component_size))
+	reached := make([]bool, len(stats.component_size))
+	seed_component := -1
+	for i := range leaves {
+		leaves[i] = true
+	}
+	for i, targets := range graph.out {
+		for _, j := range targets {
+			if stats.component[i] != stats.component[j] {
+				leaves[stats.component[i]] = false
+			}
+		}
+		if stats.click_depth[i] >= 0 {
+			reached[stats.component[i]] = true
+		}
+		if stats.click_depth[i] == 0 {
+			seed_component = stats.component[i]
+		}
+	}
+	isolated := table.NewWriter()
+	isolated.SetOutputMirror(os.Stdout)
+	isolated.AppendHeader(table.Row{"Component", "Pages", "Reached from the seed", "Examples"})
+	listed := 0
+	for component, size := range stats.component_size {
+		if size < 2 || !(leaves[component] || !reached[component]) || listed == STATS_LIST_LIMIT {
+			continue
+		}
+		// The seed's own section isn't isolated from itself
+		if component == seed_component {
+			continue
+		}
+		isolated.AppendRow(table.Row{component, size, reached[component], fmt.Sprint(pages_where(graph, stats.component, component, 3))})
+		listed += 1
+	}
+	isolated.SetTitle("Isolated sections")
+	isolated.Render()
+
+	buried := table.NewWriter()
+	buried.SetOutputMirror(os.Stdout)
+	buried.AppendHeader(table.Row{"Clicks", "Path"})
+	order := make([]int, len(graph.urls))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return stats.click_depth[order[a]] > stats.click_depth[order[b]]
+	})
+	for _, i := range order[:min_int(len(order), STATS_LIST_LIMIT)] {
+		if stats.click_depth[i] <= STATS_BURIED_DEPTH {
+			break
+		}
+		buried.AppendRow(table.Row{stats.click_depth[i], fmt.Sprint(stats.click_path(graph, i))})
+	}
+	buried.SetTitle("Pages buried deeper than " + strconv.Itoa(STATS_BURIED_DEPTH) + " clicks")
+	buried.Render()
+}
+
+// diameter_label says when the diameter is a lower bound, found from a
+// sample of the pages
+func diameter_label(graph *Link_graph, stats Graph_stats) string {
+	if stats.path_sources < len(graph.urls) {
+		return fmt.Sprintf("at least %d (from %d of the pages)", stats.diameter, stats.path_sources)
+	}
+	return strconv.Itoa(stats.diameter)
+}
+
+func display_histogram(title string, histogram map[int]int, column string) {
+	buckets := []int{}
+	for bucket := range histogram {
+		buckets = append(buckets, bucket)
+	}
+	sort.Ints(buckets)
+
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{column, "Count"})
+	for _, bucket := range buckets {
+		label := strconv.Itoa(bucket)
+		if bucket < 0 {
+			label = "unreachable"
+		}
+		t.AppendRow(table.Row{label, histogram[bucket]})
+	}
+	t.SetTitle(title)
+	t.Render()
+}
+
+// pages_where lists up to limit pages in the group
+func pages_where(graph *Link_graph, groups []int, group int, limit int) []URL {
+	pages := []URL{}
+	for i, g := range groups {
+		if g == group && len(pages) < limit {
+			pages = append(pages, graph.urls[i])
+		}
+	}
+	return pages
+}
+
+func min_int(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestStronglyConnectedComponents(t *testing.T) {
+	tests := []struct {
+		name  string
+		pages []URL
+		links [][2]URL
+		// Pages in the same group must share a component, pages in
+		// different groups must not
+		want [][]URL
+	}{
+		{"no links", []URL{"a", "b"}, nil, [][]URL{{"a"}, {"b"}}},
+		{"chain", []URL{"a", "b", "c"}, [][2]URL{{"a", "b"}, {"b", "c"}}, [][]URL{{"a"}, {"b"}, {"c"}}},
+		{"cycle", []URL{"a", "b", "c"}, [][2]URL{{"a", "b"}, {"b", "c"}, {"c", "a"}}, [][]URL{{"a", "b", "c"}}},
+		{
+			"two cycles joined one way",
+			[]URL{"a", "b", "c", "d"},
+			[][2]URL{{"a", "b"}, {"b", "a"}, {"b", "c"}, {"c", "d"}, {"d", "c"}},
+			[][]URL{{"a", "b"}, {"c", "d"}},
+		},
+		{
+			"cycle with a tail",
+			[]URL{"home", "a", "b", "c", "orphan"},
+			[][2]URL{{"home", "a"}, {"a", "b"}, {"b", "c"}, {"c", "a"}, {"orphan", "home"}},
+			[][]URL{{"home"}, {"a", "b", "c"}, {"orphan"}},
+		},
+	}
+	for _, test := range tests {
+		graph := test_graph(test.pages, test.links)
+		component := strongly_connected_components(graph)
+		group_component := make(map[int]int)
+		for group, urls := range test.want {
+			for _, url := range urls {
+				c := component[graph.nodes[url]]
+				if other, ok := group_component[c]; ok && other != group {
+					t.Errorf("%s: %s shares a component with pages of another group", test.name, url)
+				}
+				group_component[c] = group
+				if c != component[graph.nodes[urls[0]]] {
+					t.Errorf("%s: %s and %s are in different components", test.name, url, urls[0])
+				}
+			}
+		}
+	}
+}
+
+func TestLabelPropagation(t *testing.T) {
+	// Two cliques of six pages joined through the home page
+	graph := new_link_graph()
+	graph.node("home")
+	for _, clique := range []string{"a", "b"} {
+		for i := 0; i < 6; i++ {
+			graph.node(fmt.Sprint(clique, i))
+		}
+		for i := 0; i < 6; i++ {
+			for j := 0; j < 6; j++ {
+				graph.add_edge(fmt.Sprint(clique, i), fmt.Sprint(clique, j))
+			}
+		}
+		graph.add_edge("home", clique+"0")
+		graph.add_edge(clique+"0", "home")
+	}
+
+	labels := label_propagation(graph)
+	for _, clique := range []string{"a", "b"} {
+		for i := 1; i < 6; i++ {
+			if labels[graph.nodes[fmt.Sprint(clique, i)]] != labels[graph.nodes[clique+"0"]] {
+				t.Errorf("%s%d isn't in the community of %s0", clique, i, clique)
+			}
+		}
+	}
+	if labels[graph.nodes["a0"]] == labels[graph.nodes["b0"]] {
+		t.Errorf("both cliques are one community")
+	}
+}
+
+func TestPathSources(t *testing.T) {
+	if got := path_sources(3, 5); !reflect.DeepEqual(got, []int{0, 1, 2}) {
+		t.Errorf("small graph searched from %v, want every page", got)
+	}
+	sources := path_sources(1000, 10)
+	if len(sources) != 10 || !sort.IntsAreSorted(sources) || !reflect.DeepEqual(sources, path_sources(1000, 10)) {
+		t.Errorf("sources %v, want 10 sorted pages, the same on each call", sources)
+	}
+	seen := make(map[int]bool)
+	for _, source := range sources {
+		if seen[source] || source < 0 || source >= 1000 {
+			t.Errorf("bad source %d in %v", source, sources)
+		}
+		seen[source] = true
+	}
+}
+
+func TestGraphStatsPaths(t *testing.T) {
+	// A chain a -> b -> c -> d
+	graph := test_graph([]URL{"a", "b", "c", "d"}, [][2]URL{{"a", "b"}, {"b", "c"}, {"c", "d"}})
+	stats := graph_stats(graph, 0)
+	if stats.diameter != 3 || stats.path_sources != 4 {
+		t.Errorf("diameter %d from %d pages, want 3 from 4", stats.diameter, stats.path_sources)
+	}
+	want := map[int]int{1: 3, 2: 2, 3: 1}
+	if !reflect.DeepEqual(stats.path_histogram, want) {
+		t.Errorf("path histogram %v, want %v", stats.path_histogram, want)
+	}
+	if !reflect.DeepEqual(stats.click_depth, []int{0, 1, 2, 3}) {
+		t.Errorf("click depths %v", stats.click_depth)
+	}
+	if label := diameter_label(graph, stats); label != "3" {
+		t.Errorf("label %q", label)
+	}
+}
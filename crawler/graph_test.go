@@ -0,0 +1,132 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+// test_graph builds a link graph numbering pages in the order given, then
+// adds the links as from, to pairs
+func test_graph(pages []URL, links [][2]URL) *Link_graph {
+	graph := new_link_graph()
+	for _, page := range pages {
+		graph.node(page)
+	}
+	for _, link := range links {
+		graph.add_edge(link[0], link[1])
+	}
+	return graph
+}
+
+func TestPagerank(t *testing.T) {
+	tests := []struct {
+		name  string
+		pages []URL
+		links [][2]URL
+		want  []float64
+	}{
+		{"empty", nil, nil, []float64{}},
+		{"single page", []URL{"a"}, nil, []float64{1}},
+		{"cycle", []URL{"a", "b", "c"}, [][2]URL{{"a", "b"}, {"b", "c"}, {"c", "a"}}, []float64{1. / 3, 1. / 3, 1. / 3}},
+		// b is dangling and spreads its rank over both pages
+		{"dangling", []URL{"a", "b"}, [][2]URL{{"a", "b"}}, []float64{1 / 2.85, 1.85 / 2.85}},
+		{"star", []URL{"hub", "a", "b"}, [][2]URL{{"a", "hub"}, {"b", "hub"}, {"hub", "a"}, {"hub", "b"}}, []float64{0.4865, 0.2568, 0.2568}},
+	}
+	for _, test := range tests {
+		ranks := pagerank(test_graph(test.pages, test.links), PAGERANK_DAMPING)
+		if len(ranks) != len(test.want) {
+			t.Fatalf("%s: %d ranks, want %d", test.name, len(ranks), len(test.want))
+		}
+		sum := 0.0
+		for i := range ranks {
+			sum += ranks[i]
+			if math.Abs(ranks[i]-test.want[i]) > 1e-3 {
+				t.Errorf("%s: rank of %s = %.4f, want %.4f", test.name, test.pages[i], ranks[i], test.want[i])
+			}
+		}
+		if len(ranks) > 0 && math.Abs(sum-1) > 1e-9 {
+			t.Errorf("%s: ranks sum to %f", test.name, sum)
+		}
+	}
+}
+
+func TestHits(t *testing.T) {
+	tests := []struct {
+		name             string
+		pages            []URL
+		links            [][2]URL
+		want_hubs        []float64
+		want_authorities []float64
+	}{
+		{"no links", []URL{"a", "b"}, nil, []float64{0, 0}, []float64{0, 0}},
+		// Two directories linking to the same two pages
+		{
+			"bipartite",
+			[]URL{"hub1", "hub2", "a", "b"},
+			[][2]URL{{"hub1", "a"}, {"hub1", "b"}, {"hub2", "a"}, {"hub2", "b"}},
+			[]float64{math.Sqrt2 / 2, math.Sqrt2 / 2, 0, 0},
+			[]float64{0, 0, math.Sqrt2 / 2, math.Sqrt2 / 2},
+		},
+		// a is linked by both hubs, b by one, so a is the better authority
+		// and hub1, linking to both, the better hub
+		{
+			"uneven",
+			[]URL{"hub1", "hub2", "a", "b"},
+			[][2]URL{{"hub1", "a"}, {"hub1", "b"}, {"hub2", "a"}},
+			[]float64{0.8507, 0.5257, 0, 0},
+			[]float64{0, 0, 0.8507, 0.5257},
+		},
+	}
+	for _, test := range tests {
+		hubs, authorities := hits(test_graph(test.pages, test.links))
+		for i, page := range test.pages {
+			if math.Abs(hubs[i]-test.want_hubs[i]) > 1e-3 {
+				t.Errorf("%s: hub score of %s = %.4f, want %.4f", test.name, page, hubs[i], test.want_hubs[i])
+			}
+			if math.Abs(authorities[i]-test.want_authorities[i]) > 1e-3 {
+				t.Errorf("%s: authority score of %s = %.4f, want %.4f", test.name, page, authorities[i], test.want_authorities[i])
+			}
+		}
+	}
+}
+
+func TestAddEdge(t *testing.T) {
+	graph := test_graph([]URL{"a", "b"}, [][2]URL{{"a", "b"}, {"a", "b"}, {"a", "a"}, {"a", "external"}, {"external", "b"}})
+	if len(graph.urls) != 2 || len(graph.edges) != 1 || len(graph.out[0]) != 1 || len(graph.in[1]) != 1 {
+		t.Errorf("urls %v, edges %v, want a single edge from a to b", graph.urls, graph.edges)
+	}
+}
+
+func TestGraphFromIndex(t *testing.T) {
+	page := func(url URL, crawled bool, links map[URL]string) *Page {
+		related_pages := make(map[URL]Page)
+		for target, kind := range links {
+			related_pages[target] = Page{URL: target, Link_facets: Link_facets{Link_kind: kind}}
+		}
+		return &Page{URL: url, Is_crawled: crawled, related_pages: related_pages}
+	}
+	index := &Index{crawl: Crawl{Seed: "https://a.com"}, inprogress_or_done_pages: map[URL]*Page{
+		"https://a.com": page("https://a.com", true, map[URL]string{
+			"https://a.com/docs":      LINK_NAVIGATION,
+			"https://a.com/alias":     LINK_NAVIGATION,
+			"https://a.com/uncrawled": LINK_NAVIGATION,
+			"https://a.com/logo.png":  LINK_RESOURCE,
+			"https://b.org":           LINK_NAVIGATION,
+		}),
+		"https://a.com/docs":      page("https://a.com/docs", true, map[URL]string{"https://a.com": LINK_NAVIGATION}),
+		"https://a.com/uncrawled": page("https://a.com/uncrawled", false, nil),
+		"https://b.org":           page("https://b.org", true, map[URL]string{"https://a.com": LINK_NAVIGATION}),
+	}}
+	// An alias shares its canonical page
+	index.inprogress_or_done_pages["https://a.com/alias"] = index.inprogress_or_done_pages["https://a.com/docs"]
+
+	graph := graph_from_index(index)
+	if !reflect.DeepEqual(graph.urls, []URL{"https://a.com", "https://a.com/docs"}) {
+		t.Errorf("nodes %v, want the crawled pages of a.com", graph.urls)
+	}
+	want := map[[2]int]bool{{0, 1}: true, {1, 0}: true}
+	if !reflect.DeepEqual(graph.edges, want) {
+		t.Errorf("edges %v, want %v", graph.edges, want)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/dgraph-io/dgo/v2"
+	"github.com/jedib0t/go-pretty/v6/table"
+)
+
+// A Crawl is one run of the crawler against a seed url
+type Crawl struct {
+	UID          string    `json:"uid,omitempty"`
+	Run          string    `json:"run,omitempty"`
+	Seed         URL       `json:"seed,omitempty"`
+	Time_started time.Time `json:"time_started,omitempty"`
+	DType        []string  `json:"dgraph.type,omitempty"`
+}
+
+// A Snapshot is what a Page looked like in a single crawl run. Its url is
+// stored as snapshot_url so looking a page up by url never finds a snapshot
+type Snapshot struct {
+	UID          string    `json:"uid,omitempty"`
+	Run          string    `json:"run,omitempty"`
+	Seed         URL       `json:"seed,omitempty"`
+	URL          URL       `json:"snapshot_url,omitempty"`
+	Title        string    `json:"title,omitempty"`
+	Content_hash string    `json:"content_hash,omitempty"`
+	Links        []URL     `json:"links,omitempty"`
+	Summary      string    `json:"summary,omitempty"`
+	Keywords     []*string `json:"keywords,omitempty"`
+	Time_crawled time.Time `json:"time_crawled,omitempty"`
+	DType        []string  `json:"dgraph.type,omitempty"`
+}
+
+func new_crawl(target_url string) Crawl {
+	seed, ok := validate_url(target_url, target_url)
+	if !ok {
+		seed = target_url
+	}
+	now := time.Now().UTC()
+	return Crawl{
+		Run:          now.Format("20060102T150405Z"),
+		Seed:         seed,
+		Time_started: now,
+	}
+}
+
+func new_snapshot(page *Page, crawl *Crawl) Snapshot {
+	links := []URL{}
+	for url := range page.related_pages {
+		links = append(links, url)
+	}
+	sort.Strings(links)
+
+	return Snapshot{
+		Run:          crawl.Run,
+		Seed:         crawl.Seed,
+		URL:          page.URL,
+		Title:        page.Title,
+		Content_hash: page.Content_hash,
+		Links:        links,
+		Summary:      page.Summary,
+		Keywords:     page.Keywords,
+		Time_crawled: page.Time_crawled,
+		DType:        []string{"Snapshot"},
+	}
+}
+
+// ## Diff
+
+// diff_command reports what changed between two runs of the same seed. If
+// no runs are given the two most recent ones are compared
+func diff_command(args []string) {
+	if len(args) != 1 && len(args) != 3 {
+		log.Fatal(USAGE)
+	}
+
+	dg := Db_connect()
+	seed := new_crawl(args[0]).Seed
+
+	var run_a, run_b string
+	if len(args) == 3 {
+		run_a, run_b = args[1], args[2]
+	} else {
+		runs := get_latest_runs(dg, seed, 2)
+		if len(runs) < 2 {
+			log.Fatal("need at least two crawl runs to diff", "seed", seed, "runs", len(runs))
+		}
+		run_a, run_b = runs[1], runs[0]
+	}
+
+	log.Info("Diffing crawl runs", "seed", seed, "from", run_a, "to", run_b)
+	old_snapshots := get_snapshots(dg, seed, run_a)
+	new_snapshots := get_snapshots(dg, seed, run_b)
+	display_diff(diff_snapshots(old_snapshots, new_snapshots))
+}
+
+func get_latest_runs(dg *dgo.Dgraph, seed URL, count int) []string {
+	query := `query runs($seed: string, $count: int) {
+		crawls(func: eq(seed, $seed), orderdesc: time_started, first: $count) @filter(type(Crawl)) {
+			run
+		}
+	}`
+	resp, err := dg.NewReadOnlyTxn().QueryWithVars(context.Background(), query, map[string]string{
+		"$seed":  seed,
+		"$count": strconv.Itoa(count),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var result struct {
+		Crawls []Crawl `json:"crawls"`
+	}
+	if err := json.Unmarshal(resp.Json, &result); err != nil {
+		log.Fatal(err)
+	}
+
+	runs := []string{}
+	for _, crawl := range result.Crawls {
+		runs = append(runs, crawl.Run)
+	}
+	return runs
+}
+
+func get_snapshots(dg *dgo.Dgraph, seed URL, run string) map[URL]Snapshot {
+	query := `query snapshots($seed: string, $run: string) {
+		snapshots(func: eq(run, $run)) @filter(eq(seed, $seed) AND type(Snapshot)) {
+			snapshot_url
+			title
+			content_hash
+			links
+		}
+	}`
+	resp, err := dg.NewReadOnlyTxn().QueryWithVars(context.Background(), query, map[string]string{
+		"$seed": seed,
+		"$run":  run,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var result struct {
+		Snapshots []Snapshot `json:"snapshots"`
+	}
+	if err := json.Unmarshal(resp.Json, &result); err != nil {
+		log.Fatal(err)
+	}
+
+	snapshots := make(map[URL]Snapshot)
+	for _, snapshot := range result.Snapshots {
+		snapshots[snapshot.URL] = snapshot
+	}
+	return snapshots
+}
+
+type Change struct {
+	Kind   string
+	URL    URL
+	Detail string
+}
+
+func diff_snapshots(old_snapshots, new_snapshots map[URL]Snapshot) []Change {
+	changes := []Change{}
+
+	for url, new_snapshot := range new_snapshots {
+		old_snapshot, ok := old_snapshots[url]
+		if !ok {
+			changes = append(changes, Change{"page added", url, new_snapshot.Title})
+			continue
+		}
+
+		if old_snapshot.Title != new_snapshot.Title {
+			changes = append(changes, Change{"title changed", url, old_snapshot.Title + " -> " + new_snapshot.Title})
+		}
+		if old_snapshot.Content_hash != new_snapshot.Content_hash {
+			changes = append(changes, Change{"text changed", url, ""})
+		}
+
+		old_links := make(map[URL]bool)
+		for _, link := range old_snapshot.Links {
+			old_links[link] = true
+		}
+		new_links := make(map[URL]bool)
+		for _, link := range new_snapshot.Links {
+			new_links[link] = true
+			if !old_links[link] {
+				changes = append(changes, Change{"link added", url, link})
+			}
+		}
+		for _, link := range old_snapshot.Links {
+			if !new_links[link] {
+				changes = append(changes, Change{"link removed", url, link})
+			}
+		}
+	}
+
+	for url, old_snapshot := range old_snapshots {
+		if _, ok := new_snapshots[url]; !ok {
+			changes = append(changes, Change{"page removed", url, old_snapshot.Title})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].URL != changes[j].URL {
+			return changes[i].URL < changes[j].URL
+		}
+		if changes[i].Kind != changes[j].Kind {
+			return changes[i].Kind < changes[j].Kind
+		}
+		return changes[i].Detail < changes[j].Detail
+	})
+	return changes
+}
+
+func display_diff(changes []Change) {
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"URL", "Change", "Detail"})
+	for _, change := range changes {
+		t.AppendRow(table.Row{change.URL, change.Kind, change.Detail})
+	}
+	t.SetTitle("Changes between crawls")
+	t.Render()
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffSnapshots(t *testing.T) {
+	tests := []struct {
+		name string
+		old  map[URL]Snapshot
+		new  map[URL]Snapshot
+		want []Change
+	}{
+		{"no runs", nil, nil, []Change{}},
+		{
+			"unchanged",
+			map[URL]Snapshot{"a": {URL: "a", Title: "A", Content_hash: "1", Links: []URL{"b"}}},
+			map[URL]Snapshot{"a": {URL: "a", Title: "A", Content_hash: "1", Links: []URL{"b"}}},
+			[]Change{},
+		},
+		{
+			"pages added and removed",
+			map[URL]Snapshot{"a": {URL: "a", Title: "A"}, "gone": {URL: "gone", Title: "Gone"}},
+			map[URL]Snapshot{"a": {URL: "a", Title: "A"}, "new": {URL: "new", Title: "New"}},
+			[]Change{{"page removed", "gone", "Gone"}, {"page added", "new", "New"}},
+		},
+		{
+			"title, text and links",
+			map[URL]Snapshot{"a": {URL: "a", Title: "Old", Content_hash: "1", Links: []URL{"b", "c"}}},
+			map[URL]Snapshot{"a": {URL: "a", Title: "New", Content_hash: "2", Links: []URL{"c", "d"}}},
+			[]Change{{"link added", "a", "d"}, {"link removed", "a", "b"}, {"text changed", "a", ""}, {"title changed", "a", "Old -> New"}},
+		},
+	}
+	for _, test := range tests {
+		if got := diff_snapshots(test.old, test.new); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Language detection config
+// Below this many words the text is too short to tell its language, the
+// declared language is used instead
+const LANGUAGE_DETECTION_MIN_WORDS = 20
+
+// The best language must match this share of the text's trigrams, and beat
+// the runner up by LANGUAGE_DETECTION_MARGIN, to overrule the declared one
+const LANGUAGE_DETECTION_MIN_SCORE = 0.15
+const LANGUAGE_DETECTION_MARGIN = 0.05
+
+// Skip analyzers on pages in a language they don't support, rather than
+// getting poor results or errors from them
+const SKIP_UNSUPPORTED_LANGUAGES = true
+
+// Languages detection can tell apart, the ones with stopwords
+var DETECTABLE_LANGUAGES = [...]string{LANGUAGE_ENGLISH, LANGUAGE_ARABIC, LANGUAGE_FRENCH, LANGUAGE_SPANISH, LANGUAGE_GERMAN}
+
+// Character trigram profiles, built from the stopwords since they're the
+// most frequent words of each language
+var TRIGRAM_PROFILES = build_trigram_profiles()
+
+func build_trigram_profiles() map[string]map[string]bool {
+	profiles := make(map[string]map[string]bool)
+	for _, language := range DETECTABLE_LANGUAGES {
+		profiles[language] = make(map[string]bool)
+		for word := range STOPWORDS_BY_LANGUAGE[language] {
+			for _, trigram := range trigrams(word) {
+				profiles[language][trigram] = true
+			}
+		}
+	}
+	return profiles
+}
+
+// trigrams of a word padded with spaces, so short words and word edges count
+func trigrams(word string) []string {
+	runes := []rune(" " + word + " ")
+	grams := []string{}
+	for i := 0; i+3 <= len(runes); i++ {
+		grams = append(grams, string(runes[i:i+3]))
+	}
+	return grams
+}
+
+// detect_language finds the language of a page. The text wins when it's
+// long enough to be sure of, pages often keep a template's lang attribute
+// after being translated. Otherwise the lang attribute, then the
+// Content-Language header. Empty if nothing tells
+func detect_language(doc *goquery.Document, header http.Header, text string) string {
+	if language, ok := detect_text_language(text); ok {
+		return language
+	}
+	if lang, ok := doc.Find("html").Attr("lang"); ok && normalize_language(lang) != "" {
+		return normalize_language(lang)
+	}
+	// The header may list several languages, the first is the main one
+	first, _, _ := strings.Cut(header.Get("Content-Language"), ",")
+	return normalize_language(first)
+}
+
+// normalize_language reduces a language tag like "en-US" to "en"
+func normalize_language(tag string) string {
+	primary, _, _ := strings.Cut(strings.TrimSpace(tag), "-")
+	primary, _, _ = strings.Cut(primary, "_")
+	return strings.ToLower(primary)
+}
+
+// detect_text_language scores the text's character trigrams against each
+// language's profile. Arabic script is enough on its own
+func detect_text_language(text string) (string, bool) {
+	tokens := tokenize(text)
+	if len(tokens) < LANGUAGE_DETECTION_MIN_WORDS {
+		return "", false
+	}
+
+	arabic, letters := 0, 0
+	for _, r := range text {
+		if unicode.IsLetter(r) {
+			letters += 1
+			if unicode.Is(unicode.Arabic, r) {
+				arabic += 1
+			}
+		}
+	}
+	if arabic*2 > letters {
+		return LANGUAGE_ARABIC, true
+	}
+
+	scores := make(map[string]float64)
+	total := 0
+	for _, token := range tokens {
+		for _, trigram := range trigrams(token) {
+			total += 1
+			for language, profile := range TRIGRAM_PROFILES {
+				if profile[trigram] {
+					scores[language] += 1
+				}
+			}
+		}
+	}
+
+	best, best_score, runner_up := "", 0.0, 0.0
+	for _, language := range DETECTABLE_LANGUAGES {
+		score := scores[language] / float64(total)
+		if score > best_score {
+			best, best_score, runner_up = language, score, best_score
+		} else if score > runner_up {
+			runner_up = score
+		}
+	}
+	if best_score < LANGUAGE_DETECTION_MIN_SCORE || best_score-runner_up < LANGUAGE_DETECTION_MARGIN {
+		return "", false
+	}
+	return best, true
+}
+
+// document_language is the language analyzers should use for a document,
+// guessed from its stopwords when it's unknown or has no stopwords
+func document_language(document Document, tokens []string) string {
+	if _, ok := STOPWORDS_BY_LANGUAGE[document.Language]; ok {
+		return document.Language
+	}
+	return guess_language(tokens)
+}
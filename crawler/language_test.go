@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+const ENGLISH_TEXT = "The crawler visits a page, reads the links that are on it and then it follows each of them to the next page, which is how it finds all of the pages of a site over time."
+const FRENCH_TEXT = "Le robot visite une page, il lit les liens qui sont dessus et ensuite il suit chacun de ces liens vers la page suivante, c'est ainsi qu'il trouve toutes les pages du site avec le temps."
+const GERMAN_TEXT = "Der Crawler besucht eine Seite, liest die Links, die auf ihr sind, und folgt dann jedem von ihnen zu der nächsten Seite, so findet er mit der Zeit alle Seiten einer Website."
+const SPANISH_TEXT = "El robot visita una página, lee los enlaces que hay en ella y luego sigue cada uno de ellos hasta la página siguiente, así es como encuentra todas las páginas del sitio con el tiempo."
+const ARABIC_TEXT = "يزور الزاحف الصفحة ويقرأ الروابط الموجودة فيها ثم يتبع كل رابط منها إلى الصفحة التالية وهكذا يجد كل صفحات الموقع مع مرور الوقت دون أن يفوته شيء منها على الإطلاق"
+
+func TestDetectTextLanguage(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+		ok   bool
+	}{
+		{ENGLISH_TEXT, LANGUAGE_ENGLISH, true},
+		{FRENCH_TEXT, LANGUAGE_FRENCH, true},
+		{GERMAN_TEXT, LANGUAGE_GERMAN, true},
+		{SPANISH_TEXT, LANGUAGE_SPANISH, true},
+		{ARABIC_TEXT, LANGUAGE_ARABIC, true},
+		{"Too short to tell", "", false},
+	}
+	for _, test := range tests {
+		got, ok := detect_text_language(test.text)
+		if got != test.want || ok != test.ok {
+			t.Errorf("detect_text_language(%.30q) = %q, %v, want %q, %v", test.text, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		lang   string
+		header string
+		text   string
+		want   string
+	}{
+		// The text overrules a template's lang attribute
+		{"en", "", FRENCH_TEXT, LANGUAGE_FRENCH},
+		{"fr-CA", "", "Bonjour", LANGUAGE_FRENCH},
+		{"", "de-DE, en", "Hallo", LANGUAGE_GERMAN},
+		{"", "", "Hello", ""},
+	}
+	for _, test := range tests {
+		html := "<html><body></body></html>"
+		if test.lang != "" {
+			html = `<html lang="` + test.lang + `"><body></body></html>`
+		}
+		header := http.Header{}
+		header.Set("Content-Language", test.header)
+		if got := detect_language(parse_html(t, html), header, test.text); got != test.want {
+			t.Errorf("detect_language(%q, %q, %.20q) = %q, want %q", test.lang, test.header, test.text, got, test.want)
+		}
+	}
+}
+
+func TestNormalizeLanguage(t *testing.T) {
+	tests := map[string]string{"en-US": "en", " FR ": "fr", "pt_BR": "pt", "": ""}
+	for tag, want := range tests {
+		if got := normalize_language(tag); got != want {
+			t.Errorf("normalize_language(%q) = %q, want %q", tag, got, want)
+		}
+	}
+}
+
+func TestSupportsLanguage(t *testing.T) {
+	native := &Native_analyzer{}
+	if !supports_language(native, LANGUAGE_ENGLISH) {
+		t.Error("the native analyzer supports english")
+	}
+	if !supports_language(&Noop_analyzer{}, "xx") {
+		t.Error("analyzers that don't say support every language")
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	url_operations "net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/jedib0t/go-pretty/v6/table"
+)
+
+// Link check config
+const LINK_CHECK_WORKERS = 10
+const LINK_CHECK_TIMEOUT = 15 * time.Second
+
+// Redirects followed before giving up, and chains longer than
+// LONG_REDIRECT_CHAIN are reported even when they end well
+const MAX_REDIRECTS = 10
+const LONG_REDIRECT_CHAIN = 3
+
+// Problems found with a link
+const PROBLEM_CLIENT_ERROR = "client error"   // 4xx
+const PROBLEM_SERVER_ERROR = "server error"   // 5xx
+const PROBLEM_DNS = "dns failure"             // The host doesn't resolve
+const PROBLEM_TIMEOUT = "timeout"             // No answer within LINK_CHECK_TIMEOUT
+const PROBLEM_CONNECTION = "connection error" // Refused, reset, bad certificate...
+const PROBLEM_REDIRECT_LOOP = "redirect loop"
+const PROBLEM_TOO_MANY_REDIRECTS = "too many redirects"
+const PROBLEM_LONG_REDIRECT_CHAIN = "long redirect chain"
+const PROBLEM_MISSING_LOCATION = "redirect without location" // A 3xx with nowhere to go
+
+// Output formats of the check command
+const FORMAT_TABLE = "table"
+const FORMAT_CSV = "csv"
+const FORMAT_JSON = "json"
+
+type Link_check struct {
+	URL     URL    `json:"url"`
+	Status  int    `json:"status,omitempty"`
+	Problem string `json:"problem"`
+	// Every url the link redirected through, the link first
+	Redirects []URL  `json:"redirects,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Redirects are followed by hand to see the chain
+var link_check_client = &http.Client{
+	Timeout: LINK_CHECK_TIMEOUT,
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// check_command crawls the target, then checks every link found on the
+// crawled pages, in scope and out
+func check_command(args []string) {
+	if len(args) < 1 || len(args) > 2 {
+		log.Fatal(USAGE)
+	}
+	format := FORMAT_TABLE
+	if len(args) == 2 {
+		format = args[1]
+	}
+	if format != FORMAT_TABLE && format != FORMAT_CSV && format != FORMAT_JSON {
+		log.Fatal("unknown format", "format", format, "formats", []string{FORMAT_TABLE, FORMAT_CSV, FORMAT_JSON})
+	}
+
+	index := run_crawl(args[0])
+	sources := link_sources(index)
+	log.Info("Checking links", "links", len(sources))
+	broken := check_links(sources)
+	log.Info("Links checked", "links", len(sources), "broken", len(broken))
+
+	switch format {
+	case FORMAT_TABLE:
+		display_broken_links(broken, sources)
+	case FORMAT_CSV:
+		write_broken_links_csv(broken, sources)
+	case FORMAT_JSON:
+		write_broken_links_json(broken, sources)
+	}
+}
+
+// link_sources maps each link found on a crawled page to the pages it's on.
+// Links the selection policy didn't pick for crawling are checked too
+func link_sources(index *Index) map[URL][]URL {
+	sources := make(map[URL][]URL)
+	index.lock.Lock()
+	defer index.lock.Unlock()
+	for url, page := range index.inprogress_or_done_pages {
+		if page.URL != url || !page.Is_crawled {
+			continue
+		}
+		for link := range page.related_pages {
+			sources[link] = append(sources[link], page.URL)
+		}
+	}
+	for link := range sources {
+		sort.Strings(sources[link])
+	}
+	return sources
+}
+
+// check_links checks the links with LINK_CHECK_WORKERS workers and returns
+// the ones with a problem. Requests to one host are still spaced by
+// HOST_REQUEST_DELAY
+func check_links(sources map[URL][]URL) map[URL]Link_check {
+	links := make(chan URL)
+	broken := make(map[URL]Link_check)
+	var lock sync.Mutex
+	var workers sync.WaitGroup
+	for i := 0; i < LINK_CHECK_WORKERS; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for link := range links {
+				check := check_link(link)
+				if check.Problem == "" {
+					continue
+				}
+				lock.Lock()
+				broken[link] = check
+				lock.Unlock()
+			}
+		}()
+	}
+	for link := range sources {
+		links <- link
+	}
+	close(links)
+	workers.Wait()
+	return broken
+}
+
+// check_link follows a link's redirects, asking each url with HEAD and
+// falling back to GET when HEAD fails, as some servers don't support it
+func check_link(link URL) Link_check {
+	check := Link_check{URL: link, Redirects: []URL{link}}
+	seen := map[URL]bool{link: true}
+	current := link
+	for {
+		status, location, err := request_status(current)
+		if err != nil {
+			check.Problem, check.Error = request_problem(err), err.Error()
+			return check
+		}
+		check.Status = status
+
+		if status >= 300 && status < 400 && location != "" {
+			next, ok := resolve_location(current, location)
+			if !ok {
+				check.Problem, check.Error = PROBLEM_CONNECTION, "bad redirect location "+location
+				return check
+			}
+			check.Redirects = append(check.Redirects, next)
+			if seen[next] {
+				check.Problem = PROBLEM_REDIRECT_LOOP
+				return check
+			}
+			if len(check.Redirects)-1 > MAX_REDIRECTS {
+				check.Problem = PROBLEM_TOO_MANY_REDIRECTS
+				return check
+			}
+			seen[next] = true
+			current = next
+			continue
+		}
+		break
+	}
+
+	switch {
+	case check.Status >= 500:
+		check.Problem = PROBLEM_SERVER_ERROR
+	case check.Status >= 400:
+		check.Problem = PROBLEM_CLIENT_ERROR
+	case check.Status >= 300:
+		check.Problem = PROBLEM_MISSING_LOCATION
+	case len(check.Redirects)-1 > LONG_REDIRECT_CHAIN:
+		check.Problem = PROBLEM_LONG_REDIRECT_CHAIN
+	}
+	if len(check.Redirects) == 1 {
+		check.Redirects = nil
+	}
+	return check
+}
+
+// request_status asks for a url with HEAD, then GET if HEAD errors or gets
+// a 4xx or 5xx. Returns the status and the Location header
+func request_status(url URL) (int, string, error) {
+	status, location, err := send_request(http.MethodHead, url)
+	if err == nil && status < 400 {
+		return status, location, nil
+	}
+	if err != nil && request_problem(err) == PROBLEM_DNS {
+		return 0, "", err
+	}
+	return send_request(http.MethodGet, url)
+}
+
+// send_request waits for the host's turn, workers checking links on the same
+// site take turns like the spiders do
+func send_request(method string, url URL) (int, string, error) {
+	host_limiter.wait(url)
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("User-Agent", ROBOTS_USER_AGENT)
+	resp, err := link_check_client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, resp.Header.Get("Location"), nil
+}
+
+func resolve_location(current URL, location string) (URL, bool) {
+	base, err := url_operations.Parse(current)
+	if err != nil {
+		return "", false
+	}
+	next, err := url_operations.Parse(strings.TrimSpace(location))
+	if err != nil {
+		return "", false
+	}
+	return base.ResolveReference(next).String(), true
+}
+
+func request_problem(err error) string {
+	var dns_error *net.DNSError
+	if errors.As(err, &dns_error) {
+		return PROBLEM_DNS
+	}
+	var net_error net.Error
+	if errors.As(err, &net_error) && net_error.Timeout() {
+		return PROBLEM_TIMEOUT
+	}
+	return PROBLEM_CONNECTION
+}
+
+// ## Output
+
+// Broken_source is a page and the broken links on it
+type Broken_source struct {
+	Page  URL          `json:"page"`
+	Links []Link_check `json:"links"`
+}
+
+// broken_by_source groups the broken links by the pages they're on, pages
+// and links in order
+func broken_by_source(broken map[URL]Link_check, sources map[URL][]URL) []Broken_source {
+	by_page := make(map[URL][]Link_check)
+	for link, check := range broken {
+		for _, page := range sources[link] {
+			by_page[page] = append(by_page[page], check)
+		}
+	}
+
+	grouped := []Broken_source{}
+	for page, checks := range by_page {
+		sort.Slice(checks, func(i, j int) bool {
+			return checks[i].URL < checks[j].URL
+		})
+		grouped = append(grouped, Broken_source{Page: page, Links: checks})
+	}
+	sort.Slice(grouped, func(i, j int) bool {
+		return grouped[i].Page < grouped[j].Page
+	})
+	return grouped
+}
+
+func display_broken_links(broken map[URL]Link_check, sources map[URL][]URL) {
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"Page", "Broken link", "Problem", "Status", "Details"})
+	for _, source := range broken_by_source(broken, sources) {
+		for _, check := range source.Links {
+			t.AppendRow(table.Row{source.Page, check.URL, check.Problem, check.Status, link_check_details(check)}, table.RowConfig{AutoMerge: true})
+		}
+	}
+	t.SetColumnConfigs([]table.ColumnConfig{{Number: 1, AutoMerge: true}})
+	t.SetTitle("Broken links")
+	t.Render()
+}
+
+func write_broken_links_csv(broken map[URL]Link_check, sources map[URL][]URL) {
+	writer := csv.NewWriter(os.Stdout)
+	writer.Write([]string{"page", "link", "problem", "status", "redirects", "error"})
+	for _, source := range broken_by_source(broken, sources) {
+		for _, check := range source.Links {
+			writer.Write([]string{source.Page, check.URL, check.Problem, strconv.Itoa(check.Status), strings.Join(check.Redirects, " "), check.Error})
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func write_broken_links_json(broken map[URL]Link_check, sources map[URL][]URL) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(broken_by_source(broken, sources)); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func link_check_details(check Link_check) string {
+	if check.Error != "" {
+		return check.Error
+	}
+	if len(check.Redirects) > 0 {
+		return strings.Join(check.Redirects, " → ")
+	}
+	return ""
+}
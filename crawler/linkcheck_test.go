@@ -0,0 +1,132 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// link_check_server answers /ok, /status/<code>, /head-not-allowed,
+// /chain/<n> redirecting n times to end at /ok, /loop redirecting to itself
+// through /loop-back, and /no-location, a 302 without a Location
+func link_check_server() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/no-location", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusFound)
+	})
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/status/", func(w http.ResponseWriter, r *http.Request) {
+		code, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/status/"))
+		w.WriteHeader(code)
+	})
+	mux.HandleFunc("/head-not-allowed", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/chain/", func(w http.ResponseWriter, r *http.Request) {
+		n, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/chain/"))
+		if n <= 1 {
+			http.Redirect(w, r, "/ok", http.StatusFound)
+			return
+		}
+		http.Redirect(w, r, "/chain/"+strconv.Itoa(n-1), http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop-back", http.StatusFound)
+	})
+	mux.HandleFunc("/loop-back", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+	return httptest.NewServer(mux)
+}
+
+// with_host_delay swaps the shared host limiter for the length of a test
+func with_host_delay(t *testing.T, delay time.Duration) {
+	previous := host_limiter
+	host_limiter = new_host_limiter(delay)
+	t.Cleanup(func() { host_limiter = previous })
+}
+
+func TestCheckLink(t *testing.T) {
+	with_host_delay(t, 0)
+	server := link_check_server()
+	defer server.Close()
+
+	tests := []struct {
+		path      string
+		status    int
+		problem   string
+		redirects int
+	}{
+		{"/ok", 200, "", 0},
+		{"/status/404", 404, PROBLEM_CLIENT_ERROR, 0},
+		{"/status/410", 410, PROBLEM_CLIENT_ERROR, 0},
+		{"/status/503", 503, PROBLEM_SERVER_ERROR, 0},
+		{"/head-not-allowed", 200, "", 0},
+		{"/chain/1", 200, "", 1},
+		{"/chain/" + strconv.Itoa(LONG_REDIRECT_CHAIN), 200, "", LONG_REDIRECT_CHAIN},
+		{"/chain/" + strconv.Itoa(LONG_REDIRECT_CHAIN+1), 200, PROBLEM_LONG_REDIRECT_CHAIN, LONG_REDIRECT_CHAIN + 1},
+		{"/chain/" + strconv.Itoa(MAX_REDIRECTS+5), 301, PROBLEM_TOO_MANY_REDIRECTS, MAX_REDIRECTS + 1},
+		{"/loop", 302, PROBLEM_REDIRECT_LOOP, 2},
+		{"/no-location", 302, PROBLEM_MISSING_LOCATION, 0},
+	}
+	for _, test := range tests {
+		check := check_link(server.URL + test.path)
+		if check.Status != test.status || check.Problem != test.problem {
+			t.Errorf("%s: status %d, problem %q, want %d, %q", test.path, check.Status, check.Problem, test.status, test.problem)
+		}
+		// Redirects lists the link itself first when there are any
+		if redirects := len(check.Redirects) - 1; test.redirects > 0 && redirects != test.redirects {
+			t.Errorf("%s: %d redirects, want %d", test.path, redirects, test.redirects)
+		}
+		if test.redirects == 0 && check.Redirects != nil {
+			t.Errorf("%s: redirects %v, want none", test.path, check.Redirects)
+		}
+	}
+}
+
+func TestCheckLinkConnectionError(t *testing.T) {
+	with_host_delay(t, 0)
+	server := link_check_server()
+	url := server.URL + "/ok"
+	server.Close()
+
+	check := check_link(url)
+	if check.Problem != PROBLEM_CONNECTION || check.Error == "" {
+		t.Errorf("problem %q, error %q, want %q with an error", check.Problem, check.Error, PROBLEM_CONNECTION)
+	}
+}
+
+// Workers checking links on the same host take turns
+func TestCheckLinksSpacesRequests(t *testing.T) {
+	const delay = 20 * time.Millisecond
+	with_host_delay(t, delay)
+
+	var lock sync.Mutex
+	times := []time.Time{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		times = append(times, time.Now())
+		lock.Unlock()
+	}))
+	defer server.Close()
+
+	sources := make(map[URL][]URL)
+	for i := 0; i < 5; i++ {
+		sources[server.URL+"/"+strconv.Itoa(i)] = []URL{server.URL}
+	}
+	if broken := check_links(sources); len(broken) != 0 {
+		t.Errorf("broken %v", broken)
+	}
+	if len(times) != 5 {
+		t.Fatalf("%d requests, want 5", len(times))
+	}
+	if elapsed := times[len(times)-1].Sub(times[0]); elapsed < 4*delay-delay/2 {
+		t.Errorf("5 requests took %v, want them %v apart", elapsed, delay)
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// Kinds of link, stored as the kind facet of related_pages
+const LINK_NAVIGATION = "navigation"
+const LINK_RESOURCE = "resource"
+const LINK_EMBED = "embed"
+const LINK_FORM = "form"
+const LINK_REDIRECT = "redirect"
+
+// Link extraction config
+// Sources of links, turn one off to ignore the links it holds
+var LINK_SOURCES = map[string]bool{
+	"a":            true,
+	"area":         true,
+	"link":         true,
+	"iframe":       true,
+	"form":         true,
+	"img":          true,
+	"script":       true,
+	"meta_refresh": true,
+	"css":          true,
+}
+
+// Kinds of link the spiders follow, the rest are only stored as edges
+var CRAWLED_LINK_KINDS = map[string]bool{
+	LINK_NAVIGATION: true,
+	LINK_EMBED:      true,
+	LINK_REDIRECT:   true,
+}
+
+type Found_link struct {
+	url       string
+	kind      string
+	selection *goquery.Selection
+	position  int
+}
+
+// Where on the page a link sits, stored as the region facet of related_pages
+const REGION_HEAD = "head"
+const REGION_NAV = "nav"
+const REGION_HEADER = "header"
+const REGION_FOOTER = "footer"
+const REGION_ASIDE = "aside"
+const REGION_MAIN = "main"
+const REGION_BODY = "body"
+
+const MAX_ANCHOR_TEXT_LENGTH = 200
+
+type Link_extractor func(doc *goquery.Document) []Found_link
+
+var LINK_EXTRACTORS = map[string]Link_extractor{
+	"a":            attribute_extractor("a[href]", "href", LINK_NAVIGATION),
+	"area":         attribute_extractor("area[href]", "href", LINK_NAVIGATION),
+	"link":         extract_link_tags,
+	"iframe":       attribute_extractor("iframe[src], frame[src]", "src", LINK_EMBED),
+	"form":         attribute_extractor("form[action]", "action", LINK_FORM),
+	"img":          extract_images,
+	"script":       attribute_extractor("script[src]", "src", LINK_RESOURCE),
+	"meta_refresh": extract_meta_refresh,
+	"css":          extract_css_urls,
+}
+
+// Order the sources are read in, so anchors keep coming first
+var LINK_SOURCE_ORDER = [...]string{"a", "area", "meta_refresh", "iframe", "link", "form", "img", "script", "css"}
+
+// extract_links returns every link found in the enabled sources, in source
+// order and then document order. Urls are as written in the page
+func extract_links(doc *goquery.Document) []Found_link {
+	links := []Found_link{}
+	for _, source := range LINK_SOURCE_ORDER {
+		if !LINK_SOURCES[source] {
+			continue
+		}
+		links = append(links, LINK_EXTRACTORS[source](doc)...)
+	}
+
+	// Number the links by where their element sits in the document
+	positions := make(map[*html.Node]int)
+	doc.Find("*").Each(func(i int, s *goquery.Selection) {
+		positions[s.Get(0)] = i
+	})
+	in_document_order := make([]*Found_link, len(links))
+	for i := range links {
+		in_document_order[i] = &links[i]
+	}
+	sort.SliceStable(in_document_order, func(i, j int) bool {
+		return positions[in_document_order[i].selection.Get(0)] < positions[in_document_order[j].selection.Get(0)]
+	})
+	for i, link := range in_document_order {
+		link.position = i + 1
+	}
+
+	return links
+}
+
+// link_region finds the closest landmark element around a link
+func link_region(s *goquery.Selection) string {
+	for n := s; n.Length() > 0; n = n.Parent() {
+		role, _ := n.Attr("role")
+		switch {
+		case goquery.NodeName(n) == "head":
+			return REGION_HEAD
+		case goquery.NodeName(n) == "nav" || role == "navigation":
+			return REGION_NAV
+		case goquery.NodeName(n) == "header" || role == "banner":
+			return REGION_HEADER
+		case goquery.NodeName(n) == "footer" || role == "contentinfo":
+			return REGION_FOOTER
+		case goquery.NodeName(n) == "aside" || role == "complementary":
+			return REGION_ASIDE
+		case goquery.NodeName(n) == "main" || goquery.NodeName(n) == "article" || role == "main":
+			return REGION_MAIN
+		}
+	}
+	return REGION_BODY
+}
+
+// anchor_text is the visible text of a link, falling back to image alt text
+func anchor_text(s *goquery.Selection) string {
+	text := strings.Join(strings.Fields(s.Text()), " ")
+	if text == "" {
+		text, _ = s.Find("img[alt]").First().Attr("alt")
+		text = strings.TrimSpace(text)
+	}
+	if runes := []rune(text); len(runes) > MAX_ANCHOR_TEXT_LENGTH {
+		text = string(runes[:MAX_ANCHOR_TEXT_LENGTH])
+	}
+	return text
+}
+
+func attribute_extractor(selector string, attribute string, kind string) Link_extractor {
+	return func(doc *goquery.Document) []Found_link {
+		links := []Found_link{}
+		doc.Find(selector).Each(func(i int, s *goquery.Selection) {
+			url, _ := s.Attr(attribute)
+			links = append(links, Found_link{url: url, kind: kind, selection: s})
+		})
+		return links
+	}
+}
+
+// extract_link_tags reads <link href>. Pagination and alternates are
+// navigation, stylesheets, icons and the like are resources. Canonical and
+// hreflang links are handled by resolve_canonical_page
+func extract_link_tags(doc *goquery.Document) []Found_link {
+	links := []Found_link{}
+	doc.Find("link[href]").Each(func(i int, s *goquery.Selection) {
+		rel, _ := s.Attr("rel")
+		if has_rel(rel, "canonical") {
+			return
+		}
+		if _, ok := s.Attr("hreflang"); ok {
+			return
+		}
+
+		kind := LINK_RESOURCE
+		if has_rel(rel, "next") || has_rel(rel, "prev") || has_rel(rel, "alternate") {
+			kind = LINK_NAVIGATION
+		}
+		url, _ := s.Attr("href")
+		links = append(links, Found_link{url: url, kind: kind, selection: s})
+	})
+	return links
+}
+
+func extract_images(doc *goquery.Document) []Found_link {
+	links := []Found_link{}
+	doc.Find("img[src], img[srcset], source[srcset]").Each(func(i int, s *goquery.Selection) {
+		if url, ok := s.Attr("src"); ok {
+			links = append(links, Found_link{url: url, kind: LINK_RESOURCE, selection: s})
+		}
+		srcset, _ := s.Attr("srcset")
+		for _, candidate := range strings.Split(srcset, ",") {
+			// Each candidate is "<url> <descriptor>"
+			fields := strings.Fields(candidate)
+			if len(fields) == 0 {
+				continue
+			}
+			links = append(links, Found_link{url: fields[0], kind: LINK_RESOURCE, selection: s})
+		}
+	})
+	return links
+}
+
+// extract_meta_refresh reads <meta http-equiv="refresh" content="5; url=...">
+func extract_meta_refresh(doc *goquery.Document) []Found_link {
+	links := []Found_link{}
+	doc.Find("meta[http-equiv][content]").Each(func(i int, s *goquery.Selection) {
+		http_equiv, _ := s.Attr("http-equiv")
+		if !strings.EqualFold(strings.TrimSpace(http_equiv), "refresh") {
+			return
+		}
+		content, _ := s.Attr("content")
+		_, target, ok := strings.Cut(content, ";")
+		if !ok {
+			return
+		}
+		target = strings.TrimSpace(target)
+		if len(target) >= 4 && strings.EqualFold(target[:4], "url=") {
+			target = target[4:]
+		}
+		target = strings.Trim(strings.TrimSpace(target), `'"`)
+		links = append(links, Found_link{url: target, kind: LINK_REDIRECT, selection: s})
+	})
+	return links
+}
+
+var CSS_URL_PATTERN = regexp.MustCompile(`url\(\s*['"]?([^'")]+?)['"]?\s*\)`)
+
+// extract_css_urls reads url(...) from style attributes and <style> blocks
+func extract_css_urls(doc *goquery.Document) []Found_link {
+	links := []Found_link{}
+	add := func(css string, s *goquery.Selection) {
+		for _, match := range CSS_URL_PATTERN.FindAllStringSubmatch(css, -1) {
+			links = append(links, Found_link{url: match[1], kind: LINK_RESOURCE, selection: s})
+		}
+	}
+	doc.Find("[style]").Each(func(i int, s *goquery.Selection) {
+		style, _ := s.Attr("style")
+		add(style, s)
+	})
+	doc.Find("style").Each(func(i int, s *goquery.Selection) {
+		add(s.Text(), s)
+	})
+	return links
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+const LINKS_TEST_PAGE = `<html><head>
+	<meta http-equiv="refresh" content="5; URL='/moved'">
+	<link rel="stylesheet" href="/style.css">
+	<link rel="next" href="/page/2">
+	<link rel="canonical" href="/self">
+	<link rel="alternate" hreflang="ar" href="/ar">
+	<style>body { background: url("/bg.png") }</style>
+</head><body>
+	<a href="/about">About</a>
+	<img src="/logo.png" srcset="/logo-2x.png 2x, /logo-3x.png 3x">
+	<map><area href="/area"></map>
+	<iframe src="/video"></iframe>
+	<form action="/search"></form>
+	<div style="background-image: url(/tile.png)"></div>
+	<script src="/app.js"></script>
+</body></html>`
+
+func TestExtractLinks(t *testing.T) {
+	type link struct {
+		url      string
+		kind     string
+		position int
+	}
+	// Positions number the links in document order, head first
+	want := []link{
+		{"/about", LINK_NAVIGATION, 5},
+		{"/area", LINK_NAVIGATION, 9},
+		{"/moved", LINK_REDIRECT, 1},
+		{"/video", LINK_EMBED, 10},
+		{"/style.css", LINK_RESOURCE, 2},
+		{"/page/2", LINK_NAVIGATION, 3},
+		{"/search", LINK_FORM, 11},
+		{"/logo.png", LINK_RESOURCE, 6},
+		{"/logo-2x.png", LINK_RESOURCE, 7},
+		{"/logo-3x.png", LINK_RESOURCE, 8},
+		{"/app.js", LINK_RESOURCE, 13},
+		{"/tile.png", LINK_RESOURCE, 12},
+		{"/bg.png", LINK_RESOURCE, 4},
+	}
+
+	got := []link{}
+	for _, found := range extract_links(parse_html(t, LINKS_TEST_PAGE)) {
+		got = append(got, link{found.url, found.kind, found.position})
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+}
+
+func TestExtractLinksSources(t *testing.T) {
+	LINK_SOURCES["img"] = false
+	defer func() { LINK_SOURCES["img"] = true }()
+
+	for _, found := range extract_links(parse_html(t, LINKS_TEST_PAGE)) {
+		if goquery.NodeName(found.selection) == "img" {
+			t.Errorf("found %s with the img source off", found.url)
+		}
+	}
+}
+
+func TestLinkRegion(t *testing.T) {
+	doc := parse_html(t, `<html><head><link id="head" href="/x"></head><body>
+		<nav><a id="nav" href="/x">x</a></nav>
+		<div role="navigation"><a id="role-nav" href="/x">x</a></div>
+		<header><a id="header" href="/x">x</a></header>
+		<main><article><a id="main" href="/x">x</a></article><aside><a id="aside" href="/x">x</a></aside></main>
+		<div role="contentinfo"><a id="footer" href="/x">x</a></div>
+		<p><a id="body" href="/x">x</a></p>
+	</body></html>`)
+	want := map[string]string{
+		"head": REGION_HEAD, "nav": REGION_NAV, "role-nav": REGION_NAV, "header": REGION_HEADER,
+		"main": REGION_MAIN, "aside": REGION_ASIDE, "footer": REGION_FOOTER, "body": REGION_BODY,
+	}
+	for id, region := range want {
+		if got := link_region(doc.Find("#" + id)); got != region {
+			t.Errorf("%s: region %s, want %s", id, got, region)
+		}
+	}
+}
+
+func TestAnchorText(t *testing.T) {
+	long := ""
+	for len(long) < MAX_ANCHOR_TEXT_LENGTH+10 {
+		long += "word "
+	}
+	tests := []struct {
+		html string
+		want string
+	}{
+		{`<a>  Read   the
+			docs </a>`, "Read the docs"},
+		{`<a><img alt=" Logo "></a>`, "Logo"},
+		{`<a>Home <img alt="Logo"></a>`, "Home"},
+		{`<a><img src="x.png"></a>`, ""},
+		{`<a>` + long + `</a>`, long[:MAX_ANCHOR_TEXT_LENGTH]},
+	}
+	for _, test := range tests {
+		if got := anchor_text(parse_html(t, test.html).Find("a")); got != test.want {
+			t.Errorf("anchor_text(%s) = %q, want %q", test.html, got, test.want)
+		}
+	}
+}
+
+func TestLinkFacets(t *testing.T) {
+	doc := parse_html(t, `<html><body><main>
+		<a href="/docs" title=" The docs " rel="NoFollow  External">Read the docs</a>
+		<a href="/about">About</a>
+	</main></body></html>`)
+	links := find_related_pages(doc, &Page{URL: "https://a.com/", Depth: 1})
+
+	docs := links["https://a.com/docs"]
+	want := Link_facets{
+		Link_nofollow: true,
+		Link_kind:     LINK_NAVIGATION,
+		Anchor_text:   "Read the docs",
+		Link_title:    "The docs",
+		Link_rel:      "nofollow external",
+		Link_region:   REGION_MAIN,
+		Link_position: 1,
+	}
+	if docs.Link_facets != want {
+		t.Errorf("facets %+v, want %+v", docs.Link_facets, want)
+	}
+	if docs.Depth != 2 {
+		t.Errorf("depth %d, want 2", docs.Depth)
+	}
+	if about := links["https://a.com/about"]; about.Link_nofollow || about.Link_position != 2 {
+		t.Errorf("about facets %+v", about.Link_facets)
+	}
+}
@@ -1,14 +1,16 @@
 package main
 
 import (
-	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
-	"io/ioutil"
 	"net/http"
 	url_operations "net/url"
 	"os"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/charmbracelet/log"
@@ -21,21 +23,50 @@ import (
 
 // Config
 const MAX_DEPTH = 30
-const MAX_URL_PER_PAGE = 5
-const MAX_URLS_PER_PAGE_PER_DOMAIN = 5
 
 const SPIDER_COUNT = 5
 const MAX_PAGES_BUFFER = 10000
 const CRAWL_TIME = 70 * time.Second
+const FETCH_TIMEOUT = 20 * time.Second
+
+// Least time between two requests to the same host, by the spiders and by
+// the link checker
+const HOST_REQUEST_DELAY = 200 * time.Millisecond
+
+// Keep the data of previous crawls so runs can be diffed against each other
+const DROP_ALL_ON_START = false
 
 // Analyzer config
+// Analyzers run on every page in this order, see analyzer.go for the backends
+var ANALYZERS = []string{ANALYZER_HTTP, ANALYZER_TFIDF, ANALYZER_ENTITIES}
+
 const PORT = "9898"
 const ANALYZER_URL = "http://localhost:" + PORT
 
-var ANALYZER_ENDPOINTS = map[string]string{
-	"keywords": ANALYZER_URL + "/keywords",
-	"summary":  ANALYZER_URL + "/summarize",
-}
+const GRPC_PORT = "9899"
+const ANALYZER_GRPC_ADDRESS = "localhost:" + GRPC_PORT
+
+// What remote analyzers are asked for, tasks a service lacks are left out
+var ANALYZER_TASKS = []string{TASK_SUMMARY, TASK_KEYWORDS, TASK_ENTITIES}
+
+const ANALYSIS_WORKERS = 4
+const ANALYSIS_BATCH_SIZE = 8
+const ANALYSIS_BATCH_WAIT = 2 * time.Second
+
+// Analyzer results are cached here across runs, empty to turn the cache off
+const ANALYSIS_CACHE_DIR = ".analysis_cache"
+
+// A call to a remote analyzer times out after ANALYZER_TIMEOUT plus
+// ANALYZER_DOCUMENT_TIMEOUT per document, a BART summary takes a while on a CPU
+const ANALYZER_TIMEOUT = 10 * time.Second
+const ANALYZER_DOCUMENT_TIMEOUT = 30 * time.Second
+const ANALYZER_MAX_FAILURES = 3
+const ANALYZER_COOLDOWN = 30 * time.Second
+
+// How long a service's capabilities are trusted, and how long a failure to
+// get them is, before asking again
+const ANALYZER_CAPABILITIES_TTL = 5 * time.Minute
+const ANALYZER_CAPABILITIES_RETRY = 5 * time.Second
 
 // This is a var but please don't change it :)
 var SPIDER_NAMES = [...]string{
@@ -164,19 +195,66 @@ var SPIDER_NAMES = [...]string{
 type URL = string
 
 type Page struct {
-	UID           string    `json:"uid,omitempty"`
-	URL           URL       `json:"url,omitempty"`
-	Domain        Domain    `json:"domain,omitempty"`
-	Title         string    `json:"title,omitempty"`
-	Related_pages []Page    `json:"related_pages,omitempty"`
-	Is_crawled    bool      `json:"is_crawled,omitempty"`
-	Depth         uint      `json:"depth,omitempty"`
-	Time_crawled  time.Time `json:"time_crawled,omitempty"`
-	Time_found    time.Time `json:"time_found,omitempty"`
-	DType         []string  `json:"dgraph.type,omitempty"`
-	Summary       string    `json:"summary,omitempty"`
-	Keywords      []*string `json:"keywords,omitempty"`
-	related_pages map[URL]Page
+	UID             string          `json:"uid,omitempty"`
+	URL             URL             `json:"url,omitempty"`
+	Domain          Domain          `json:"domain,omitempty"`
+	Title           string          `json:"title,omitempty"`
+	Related_pages   []Page          `json:"related_pages,omitempty"`
+	Is_crawled      bool            `json:"is_crawled,omitempty"`
+	Depth           uint            `json:"depth,omitempty"`
+	Time_crawled    time.Time       `json:"time_crawled,omitempty"`
+	Time_found      time.Time       `json:"time_found,omitempty"`
+	DType           []string        `json:"dgraph.type,omitempty"`
+	Summary         string          `json:"summary,omitempty"`
+	Keywords        []*string       `json:"-"` // Kept on snapshots, the page links to Topic nodes instead
+	Topics          []Topic         `json:"topics,omitempty"`
+	Text            string          `json:"text,omitempty"`
+	Headings        []string        `json:"headings,omitempty"`
+	Word_count      int             `json:"word_count,omitempty"`
+	Language        string          `json:"language,omitempty"`
+	Description     string          `json:"description,omitempty"`
+	Describes       []Schema_entity `json:"describes,omitempty"`
+	Mentions        []Named_entity  `json:"mentions,omitempty"`
+	Content_hash    string          `json:"content_hash,omitempty"`
+	Snapshots       []Snapshot      `json:"snapshots,omitempty"`
+	Noindex         bool            `json:"noindex,omitempty"`
+	Nofollow        bool            `json:"nofollow,omitempty"`
+	Aliases         []Page          `json:"aliases,omitempty"`
+	Translations    []Page          `json:"translations,omitempty"`
+	Links_discarded int             `json:"links_discarded,omitempty"`
+	Fingerprint     string          `json:"fingerprint,omitempty"`
+	Duplicate_of    *Page           `json:"duplicate_of,omitempty"`
+	Sitemaps        []URL           `json:"sitemaps,omitempty"`
+	H1_count        int             `json:"h1_count,omitempty"`
+	Missing_alt     int             `json:"missing_alt,omitempty"`
+	related_pages   map[URL]Page
+	annotations     map[string]Annotations
+	needs_analysis  bool
+
+	// Typed predicates from the page's meta tags
+	Open_graph_tags
+	Twitter_tags
+
+	// Scores from the link graph, see graph.go
+	Link_scores
+
+	// Facets of the related_pages edge pointing at this page
+	Link_facets
+}
+
+type Link_facets struct {
+	Link_nofollow        bool    `json:"related_pages|nofollow,omitempty"`
+	Alias_reason         string  `json:"aliases|reason,omitempty"`
+	Hreflang             string  `json:"translations|hreflang,omitempty"`
+	Link_kind            string  `json:"related_pages|kind,omitempty"`
+	Anchor_text          string  `json:"related_pages|anchor_text,omitempty"`
+	Link_title           string  `json:"related_pages|title,omitempty"`
+	Link_rel             string  `json:"related_pages|rel,omitempty"`
+	Link_region          string  `json:"related_pages|region,omitempty"`
+	Link_position        int     `json:"related_pages|position,omitempty"`
+	Link_selected        bool    `json:"related_pages|selected,omitempty"`
+	Duplicate_similarity float64 `json:"duplicate_of|similarity,omitempty"`
+	Similarity           float64 `json:"similar_to|score,omitempty"`
 }
 
 type Domain struct {
@@ -187,6 +265,30 @@ type Domain struct {
 type Index struct {
 	inprogress_or_done_pages map[URL]*Page
 	pages_to_crawl           chan Page
+	crawl                    Crawl
+	lock                     sync.Mutex
+	// Closed when the crawl time is up
+	stop chan struct{}
+	// Crawled pages waiting for the analysis stage
+	pages_to_analyze chan *Page
+	// Pages an analyzer failed on, analyzed again once the crawl is over
+	analysis_backlog []*Page
+	duplicates       *Duplicate_index
+	clusters         []Cluster
+	// Pages listed in the seed's sitemaps, with the sitemaps listing them
+	sitemaps map[URL][]URL
+	issues   []Issue
+}
+
+// claim marks a url as inprogress, returns false if it was already taken
+func (index *Index) claim(url URL, page *Page) bool {
+	index.lock.Lock()
+	defer index.lock.Unlock()
+	if _, ok := index.inprogress_or_done_pages[url]; ok {
+		return false
+	}
+	index.inprogress_or_done_pages[url] = page
+	return true
 }
 
 type Spider struct {
@@ -195,25 +297,87 @@ type Spider struct {
 	logger *log.Logger
 }
 
+const USAGE = `Usage:
+	go run . <target_url>
+	go run . diff <target_url> [<run_a> <run_b>]
+	go run . check <target_url> [table|csv|json]
+	go run . rank <target_url>
+	go run . stats <target_url>
+	go run . orphans <target_url> [<seed_list>...]
+	go run . analyzer-stub`
+
 func main() {
-	if len(os.Args) != 2 {
-		log.Fatal("Usage: go run . <target_url>")
+	if len(os.Args) < 2 {
+		log.Fatal(USAGE)
 	}
 
+	switch os.Args[1] {
+	case "diff":
+		diff_command(os.Args[2:])
+	case "check":
+		check_command(os.Args[2:])
+	case "rank":
+		rank_command(os.Args[2:])
+	case "stats":
+		stats_command(os.Args[2:])
+	case "orphans":
+		orphans_command(os.Args[2:])
+	case "analyzer-stub":
+		stub_command()
+	default:
+		if len(os.Args) != 2 {
+			log.Fatal(USAGE)
+		}
+		crawl_command(os.Args[1])
+	}
+}
+
+func crawl_command(target_url string) {
+	index := run_crawl(target_url)
+
+	log.Infof("Nest destroyed; pages conqured:")
+	display_crawled_pages(index)
+	display_clusters(index.clusters)
+	display_issues(index.issues)
+
+	log.Infof("Totalling %d pages", len(index.inprogress_or_done_pages))
+	log.Infof("%d of them near-duplicates", index.duplicates.count)
+}
+
+// run_crawl crawls from the target until CRAWL_TIME is up, then analyzes
+// the pages it found
+func run_crawl(target_url string) *Index {
 	dg := Db_setup()
 
-	target_url := os.Args[1]
 	log.Infof("Nest established; target %s", target_url)
 
 	index := Index{
 		inprogress_or_done_pages: make(map[URL]*Page),
 		pages_to_crawl:           make(chan Page, MAX_PAGES_BUFFER),
+		crawl:                    new_crawl(target_url),
+		stop:                     make(chan struct{}),
+		pages_to_analyze:         make(chan *Page, MAX_PAGES_BUFFER),
+		duplicates:               new_duplicate_index(),
 	}
+	Db_add_crawl(dg, &index.crawl)
+	log.Info("Crawl run started", "run", index.crawl.Run)
+
 	index.pages_to_crawl <- Page{
 		URL: target_url,
 	}
 
+	var cache *Analysis_cache
+	if ANALYSIS_CACHE_DIR != "" {
+		cache = new_analysis_cache(ANALYSIS_CACHE_DIR)
+	}
+	analyzers, err := new_analyzers(ANALYZERS, cache)
+	if err != nil {
+		log.Fatal(err)
+	}
+	analysis := start_analysis(&index, analyzers, dg)
+
 	// Create spiders
+	var spiders sync.WaitGroup
 	for i := 0; i < SPIDER_COUNT; i++ {
 		spider := Spider{
 			id:   i,
@@ -224,38 +388,96 @@ func main() {
 				Prefix:          SPIDER_NAMES[i],
 			}),
 		}
-		go spider.crawl(&index, dg)
+		spiders.Add(1)
+		go func() {
+			defer spiders.Done()
+			spider.crawl(&index, dg)
+		}()
 	}
 
 	time.Sleep(CRAWL_TIME)
 
-	log.Infof("Nest destroyed; pages conqured:")
-	display_crawled_pages(&index)
+	// Let the spiders finish the pages they're on
+	close(index.stop)
+	spiders.Wait()
 
-	log.Infof("Totalling %d pages", len(index.inprogress_or_done_pages))
+	// Then let the analysis stage catch up
+	close(index.pages_to_analyze)
+	analysis.Wait()
 
+	if SITEMAP_DISCOVERY {
+		record_sitemaps(&index, dg)
+	}
+	retry_analysis_backlog(&index, analyzers, dg)
+	rescore_pages(&index, analyzers, dg)
+	build_topic_graph(&index, dg)
+	documents, vectors := page_vectors(&index)
+	link_similar_pages(documents, vectors, dg)
+	index.clusters = cluster_pages(documents, vectors, &index, dg)
+	rank_pages(&index, dg)
+	index.issues = audit_pages(&index, dg)
+	if cache != nil {
+		cache.report()
+	}
+	return &index
 }
 
 // ## Spider functions
 
+var fetch_client = &http.Client{Timeout: FETCH_TIMEOUT}
+
 func (spider *Spider) crawl(index *Index, dg *dgo.Dgraph) {
 	spider.logger.Infof("started crawling")
 	for {
 		page_to_crawl := spider.fetch_page(index)
+		if page_to_crawl == nil {
+			spider.logger.Infof("stopped crawling")
+			return
+		}
 
+		original_url := page_to_crawl.URL
 		related_pages := spider.crawl_page(page_to_crawl)
-		if related_pages != nil {
+
+		// The page turned out to be an alias of another page
+		if page_to_crawl.URL != original_url && !index.claim(page_to_crawl.URL, page_to_crawl) {
+			spider.logger.Info("page is an alias of an already crawled page", "URL", original_url, "canonical", page_to_crawl.URL)
+			spider.add_page_to_db(&Page{URL: page_to_crawl.URL, Domain: page_to_crawl.Domain, Aliases: page_to_crawl.Aliases}, &index.crawl, dg)
+			continue
+		}
+		for _, alias := range page_to_crawl.Aliases {
+			index.claim(alias.URL, page_to_crawl)
+		}
+
+		is_duplicate := page_to_crawl.Fingerprint != "" && index.duplicates.check(page_to_crawl)
+		if is_duplicate {
+			spider.logger.Info("page is a near-duplicate", "URL", page_to_crawl.URL, "of", page_to_crawl.Duplicate_of.URL)
+		}
+
+		if related_pages != nil && (!is_duplicate || FOLLOW_DUPLICATE_LINKS) {
 			spider.add_related_pages(page_to_crawl, index)
 		}
 		spider.logger.Info("finished crawling page", "URL", page_to_crawl.URL, "related pages count", len(related_pages), "index", len(index.pages_to_crawl))
 
-		spider.add_page_to_db(page_to_crawl, dg)
+		spider.add_page_to_db(page_to_crawl, &index.crawl, dg)
+
+		// Analysis updates the page once it's stored
+		if page_to_crawl.needs_analysis {
+			index.queue_analysis(page_to_crawl)
+		}
 	}
 }
 
 func (spider *Spider) add_related_pages(page *Page, index *Index) {
+	for _, translation := range page.Translations {
+		select {
+		case index.pages_to_crawl <- Page{URL: translation.URL, Time_found: time.Now(), Depth: page.Depth + 1}:
+		default:
+			return
+		}
+	}
+
 	for _, related_page := range page.related_pages {
-		if page.URL == related_page.URL {
+		if !related_page.Link_selected || !is_crawlable_link(related_page, page) {
 			continue
 		}
 
@@ -268,14 +490,19 @@ func (spider *Spider) add_related_pages(page *Page, index *Index) {
 	}
 }
 
+// fetch_page returns nil once the crawl is stopped
 func (spider *Spider) fetch_page(index *Index) *Page {
 	for {
-		page_to_crawl := <-index.pages_to_crawl
+		var page_to_crawl Page
+		select {
+		case page_to_crawl = <-index.pages_to_crawl:
+		case <-index.stop:
+			return nil
+		}
 		// If the page is already inprogress or done then skip
-		if _, ok := index.inprogress_or_done_pages[page_to_crawl.URL]; ok {
+		if !index.claim(page_to_crawl.URL, &page_to_crawl) {
 			continue
 		}
-		index.inprogress_or_done_pages[page_to_crawl.URL] = &page_to_crawl
 		return &page_to_crawl
 	}
 }
@@ -285,7 +512,8 @@ func (spider *Spider) crawl_page(page *Page) map[URL]Page {
 		return nil
 	}
 
-	resp, err := http.Get(page.URL)
+	host_limiter.wait(page.URL)
+	resp, err := fetch_client.Get(page.URL)
 	if err != nil {
 		spider.logger.Warn(err)
 		return nil
@@ -298,24 +526,48 @@ func (spider *Spider) crawl_page(page *Page) map[URL]Page {
 		return nil
 	}
 
-	// Get page summary and keywords
-	html, err := doc.Html()
-	if err != nil {
-		spider.logger.Warn(err)
-		return nil
+	// Collapse redirects and <link rel="canonical"> into one page
+	resolve_canonical_page(page, doc, resp.Request.URL.String())
+	for url, hreflang := range get_language_alternates(doc, page.URL) {
+		page.Translations = append(page.Translations, Page{
+			URL: url,
+			Link_facets: Link_facets{
+				Hreflang: hreflang,
+			},
+		})
+	}
+
+	robots := get_robots_directives(doc, resp.Header)
+	page.Noindex = robots.noindex
+	page.Nofollow = robots.nofollow
+
+	// Titles and meta tags identify the page in reports, noindex or not
+	page.Title = doc.Find("title").Text()
+	page.H1_count = doc.Find("h1").Length()
+	// An empty alt marks a decorative image, only a missing one is a problem
+	page.Missing_alt = doc.Find("img:not([alt])").Length()
+	extract_metadata(doc, page)
+
+	// Get page content for analysis, unless the page asks not to be indexed
+	if !(OBEY_ROBOTS_DIRECTIVES && page.Noindex) {
+		content := extract_content(doc)
+		page.Text = content.text
+		page.Headings = content.headings
+		page.Word_count = content.word_count
+		page.Language = detect_language(doc, resp.Header, page.Text)
+		page.needs_analysis = true
+		page.Content_hash = content_hash(page.Text)
+		if fingerprint, ok := simhash(page.Text); ok {
+			page.Fingerprint = format_fingerprint(fingerprint)
+		}
 	}
-	page.Summary = get_summary(page, html)
-	page.Keywords = get_keywords(page, html)
 
 	// Get page domain
-	parsed_url, err := url_operations.Parse(page.URL)
+	domain, err := domain_name(page.URL)
 	if err != nil {
 		spider.logger.Warn(err)
 		return nil
 	}
-
-	parts := strings.Split(parsed_url.Hostname(), ".")
-	domain := parts[len(parts)-2] + "." + parts[len(parts)-1]
 	page.Domain = Domain{
 		Name: domain,
 	}
@@ -324,42 +576,73 @@ func (spider *Spider) crawl_page(page *Page) map[URL]Page {
 	page.related_pages = find_related_pages(doc, page)
 
 	// Mark the page as crawled
-	page.Title = doc.Find("title").Text()
 	page.Time_crawled = time.Now()
 	page.Is_crawled = true
 
 	return page.related_pages
 }
 
-func (spider *Spider) add_page_to_db(page *Page, dg *dgo.Dgraph) {
+// Predicates read from the page on each crawl, replaced when it's crawled
+// again. Annotations, link scores and sitemaps are replaced by their own stages
+var PAGE_CRAWL_PREDICATES = []string{
+	"title", "text", "headings", "word_count", "language", "description", "content_hash", "fingerprint",
+	"noindex", "nofollow", "links_discarded", "h1_count", "missing_alt",
+	"og_title", "og_description", "og_type", "og_image", "og_site_name", "og_url",
+	"twitter_card", "twitter_title", "twitter_description", "twitter_image", "twitter_site",
+	"related_pages", "describes", "duplicate_of", "aliases", "translations",
+}
+
+func (spider *Spider) add_page_to_db(page *Page, crawl *Crawl, dg *dgo.Dgraph) {
 	// Add current page to the DB # how you know
 	Related_page := []Page{}
 	for _, p := range page.related_pages {
-		Related_page = append(Related_page, p)
+		// Only link to the page, its details are stored once it's crawled
+		Related_page = append(Related_page, Page{URL: p.URL, Link_facets: p.Link_facets})
 	}
 	page.Related_pages = Related_page
 
+	// The page is stored as a root node, facets only make sense on its in-edges
+	page.Link_facets = Link_facets{}
+
+	// Record what the page looked like in this run, appended to its history
+	if page.Is_crawled {
+		page.Snapshots = []Snapshot{new_snapshot(page, crawl)}
+	}
+
 	// Create a new transaction
 	txn := dg.NewTxn()
 
 	// Create a new request
 	req := &api.Request{CommitNow: true}
 
-	// Create a new query
-	req.Query = `query {
-		page(func: eq(url, "` + page.URL + `")) {
-			v as uid
+	// Create a new query, linked pages are matched by url so they aren't duplicated
+	upsert := new_upsert()
+	page.UID = upsert.match("url", page.URL)
+	page.Domain.UID = upsert.match("name", page.Domain.Name)
+	for i := range page.Describes {
+		page.Describes[i].DType = []string{"Schema_entity", page.Describes[i].Schema_type}
+		if page.Describes[i].Entity_id != "" {
+			page.Describes[i].UID = upsert.match("entity_id", page.Describes[i].Entity_id)
 		}
-
-		domain(func: eq(name, "` + page.Domain.Name + `")) {
-			d as uid
+	}
+	for _, linked_pages := range [][]Page{page.Related_pages, page.Aliases, page.Translations} {
+		for i := range linked_pages {
+			linked_pages[i].UID = upsert.match("url", linked_pages[i].URL)
 		}
 	}
-	`
-
-	// Create a new mutation
-	page.UID = "uid(v)"
-	page.Domain.UID = "uid(d)"
+	for i := range page.Topics {
+		page.Topics[i].DType = []string{"Topic"}
+		page.Topics[i].UID = upsert.match("topic_name", page.Topics[i].Name)
+	}
+	for i := range page.Mentions {
+		page.Mentions[i].DType = []string{"Entity"}
+		page.Mentions[i].UID = upsert.match("named_entity_key", page.Mentions[i].Key)
+	}
+	if page.Duplicate_of != nil {
+		page.Duplicate_of.UID = upsert.match("url", page.Duplicate_of.URL)
+	}
+	req.Query = upsert.query()
+	req.Vars = upsert.vars
 
 	// Marshal the new Page node into a JSON byte array
 	newPageBytes, err := json.Marshal(page)
@@ -372,6 +655,16 @@ func (spider *Spider) add_page_to_db(page *Page, dg *dgo.Dgraph) {
 		SetJson: newPageBytes,
 	}
 
+	// Data is kept between runs, so what a crawled page no longer has goes.
+	// Deletes apply before the set, empty fields are left out of the json
+	if page.Is_crawled {
+		del := []string{}
+		for _, predicate := range PAGE_CRAWL_PREDICATES {
+			del = append(del, page.UID+" <"+predicate+"> * .")
+		}
+		mu.DelNquads = []byte(strings.Join(del, "\n"))
+	}
+
 	// Add the mutation to the request
 	req.Mutations = []*api.Mutation{mu}
 
@@ -387,108 +680,108 @@ func (spider *Spider) add_page_to_db(page *Page, dg *dgo.Dgraph) {
 	err = txn.Commit(context.Background())
 }
 
-func get_summary(page *Page, html string) string {
-	resp, err := http.Post("http://localhost:9898/summarize", "application/json", bytes.NewBuffer([]byte(html)))
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Fatal(err)
-	}
-	return string(body)
-}
-
-func get_keywords(page *Page, html string) []*string {
-	resp, err := http.Post(ANALYZER_ENDPOINTS["keywords"], "application/json", bytes.NewBuffer([]byte(html)))
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	var keywords []*string
-	err = json.Unmarshal(body, &keywords)
-	if err != nil {
-		return []*string{}
-	}
-
-	return keywords
-}
-
 // ## Page functions
 
 func find_related_pages(doc *goquery.Document, current_page *Page) map[URL]Page {
-	related_pages := make(map[URL]Page)
-	url_domain_to_count := make(map[string]int)
-
-	count_added := 0
-	doc.Find("a").Each(func(i int, s *goquery.Selection) {
-		if count_added > MAX_URL_PER_PAGE {
-			return
-		}
-
-		url, _ := s.Attr("href")
+	found_pages := []Page{}
+	found_urls := make(map[URL]int)
 
+	for _, link := range extract_links(doc) {
 		// If the url is invalid then skip
-		url, ok := validate_url(url, current_page.URL)
-		if !ok {
-			return
-		}
-
-		// If the url is in extracted_pages already then skip
-		if _, ok := related_pages[url]; ok {
-			return
-		}
-
-		// If we're getting lotsa urls from the same domain then skip
-		url, ok = validate_max_url_count_per_domain(url, url_domain_to_count)
+		url, ok := validate_url(link.url, current_page.URL)
 		if !ok {
-			return
-		}
-
-		// Figure out the domain of the url
-		parsed_url, err := url_operations.Parse(url)
-		if err != nil {
-			log.Fatal(err)
-		}
-		parts := strings.Split(parsed_url.Hostname(), ".")
-		domain := parts[len(parts)-2] + "." + parts[len(parts)-1]
-		url_domain_to_count[domain] += 1
-		if url_domain_to_count[domain] > MAX_URLS_PER_PAGE_PER_DOMAIN {
-			log.Debug("skipping url", "URL", url, "reason", "too many urls from the same domain", "domain", domain)
-			return
+			continue
 		}
 
+		rel, _ := link.selection.Attr("rel")
+		title, _ := link.selection.Attr("title")
 		new_page := Page{
 			URL:        url,
 			Is_crawled: false,
 			Time_found: time.Now(),
 			Depth:      current_page.Depth + 1,
+			Link_facets: Link_facets{
+				Link_nofollow: current_page.Nofollow || has_rel(rel, "nofollow"),
+				Link_kind:     link.kind,
+				Anchor_text:   anchor_text(link.selection),
+				Link_title:    strings.TrimSpace(title),
+				Link_rel:      strings.Join(strings.Fields(strings.ToLower(rel)), " "),
+				Link_region:   link_region(link.selection),
+				Link_position: link.position,
+			},
+		}
+
+		// A url linked several times keeps its best link, so a content link
+		// isn't lost to the same link in the nav
+		if i, ok := found_urls[url]; ok {
+			if link_score(new_page) > link_score(found_pages[i]) {
+				found_pages[i] = new_page
+			}
+			continue
 		}
+		found_urls[url] = len(found_pages)
+		found_pages = append(found_pages, new_page)
+	}
 
-		related_pages[url] = new_page
-		count_added += 1
+	// Links come grouped by source, policies pick from them in document order
+	sort.SliceStable(found_pages, func(i, j int) bool {
+		return found_pages[i].Link_position < found_pages[j].Link_position
 	})
+
+	// Every link is kept, the selection policy only picks the ones crawled.
+	// Only links the spiders would follow go through it, so images and
+	// scripts don't use up its limit
+	related_pages := make(map[URL]Page)
+	crawlable := []Page{}
+	for _, page := range found_pages {
+		related_pages[page.URL] = page
+		if is_crawlable_link(page, current_page) {
+			crawlable = append(crawlable, page)
+		}
+	}
+	selected_pages, discarded := select_links(crawlable)
+	current_page.Links_discarded = discarded
+
+	for _, page := range selected_pages {
+		page.Link_selected = true
+		related_pages[page.URL] = page
+	}
 	return related_pages
 }
 
+// is_crawlable_link is true for the links of a page the spiders follow
+func is_crawlable_link(link Page, current_page *Page) bool {
+	if link.URL == current_page.URL || !CRAWLED_LINK_KINDS[link.Link_kind] {
+		return false
+	}
+	return !(OBEY_ROBOTS_DIRECTIVES && link.Link_nofollow)
+}
+
 func validate_url(url string, current_url URL) (URL, bool) {
+	url = strings.TrimSpace(url)
+	if url == "" {
+		return "", false
+	}
+
 	// If link is relative then make it absolute
-	if strings.HasPrefix(url, "/") {
+	parsed_url, err := url_operations.Parse(url)
+	if err != nil {
+		return "", false
+	}
+	if parsed_url.Scheme == "" {
 		// Get base of current page
 		base_url, err := url_operations.Parse(current_url)
 		if err != nil {
 			return "", false
 		}
 
-		url = base_url.Scheme + "://" + base_url.Host + url
+		parsed_url = base_url.ResolveReference(parsed_url)
 	}
 
+	// Fragments point inside the same page
+	parsed_url.Fragment = ""
+	url = parsed_url.String()
+
 	// If link is not http or https then mark it as invalid
 	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
 		return "", false
@@ -505,34 +798,56 @@ func validate_url(url string, current_url URL) (URL, bool) {
 	return url, true
 }
 
-func validate_max_url_count_per_domain(url URL, url_domain_to_count map[string]int) (URL, bool) {
+// domain_name is the last two labels of a url's host, "example.com" for
+// "https://blog.example.com/post"
+func domain_name(url URL) (string, error) {
 	parsed_url, err := url_operations.Parse(url)
 	if err != nil {
-		return "", false
+		return "", err
 	}
 	parts := strings.Split(parsed_url.Hostname(), ".")
 	if len(parts) < 2 {
-		return "", false
+		return parsed_url.Hostname(), nil
 	}
-	domain := parts[len(parts)-2] + "." + parts[len(parts)-1]
-	url_domain_to_count[domain] += 1
-	if url_domain_to_count[domain] > MAX_URLS_PER_PAGE_PER_DOMAIN {
-		return "", false
-	}
-	return url, true
+	return parts[len(parts)-2] + "." + parts[len(parts)-1], nil
+}
+
+// content_hash hashes the text of a page with whitespace collapsed, so
+// re-indented markup doesn't count as a change
+func content_hash(text string) string {
+	sum := sha256.Sum256([]byte(strings.Join(strings.Fields(text), " ")))
+	return hex.EncodeToString(sum[:])
 }
 
 // ## Misc functions
 
+// display_crawled_pages lists the crawled pages by PageRank
 func display_crawled_pages(index *Index) {
-	t := table.NewWriter()
-	t.SetOutputMirror(os.Stdout)
-	t.AppendHeader(table.Row{"URL", "Title", "Depth", "Number of related pages"})
+	index.lock.Lock()
+	defer index.lock.Unlock()
+	pages := []*Page{}
 	for url, page := range index.inprogress_or_done_pages {
 		if page.Is_crawled == false {
 			continue
 		}
-		t.AppendRow(table.Row{url, page.Title, page.Depth, len(page.related_pages)})
+		// Aliases share the page of their canonical url
+		if url != page.URL {
+			continue
+		}
+		pages = append(pages, page)
+	}
+	sort.Slice(pages, func(i, j int) bool {
+		if pages[i].Pagerank != pages[j].Pagerank {
+			return pages[i].Pagerank > pages[j].Pagerank
+		}
+		return pages[i].URL < pages[j].URL
+	})
+
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"URL", "Title", "Depth", "Number of related pages", "PageRank", "In", "Out"})
+	for _, page := range pages {
+		t.AppendRow(table.Row{page.URL, page.Title, page.Depth, len(page.related_pages), format_score(page.Pagerank), page.In_degree, page.Out_degree})
 	}
 	t.SetTitle("Crawled pages")
 	t.Render()
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/log"
+)
+
+// Page predicates that aren't read from the page itself, other stages or the
+// crawl bookkeeping set them
+var NOT_CRAWL_PREDICATES = make_set([]string{
+	"uid", "url", "domain", "is_crawled", "depth", "time_crawled", "time_found", "dgraph.type",
+	"summary", "topics", "mentions", "snapshots", "sitemaps",
+	"pagerank", "hub_score", "authority_score", "in_degree", "out_degree",
+})
+
+// Every predicate a crawl fills in must be cleared on the next crawl, or a
+// page that fixes a problem keeps it in Dgraph
+func TestPageCrawlPredicates(t *testing.T) {
+	listed := make_set(PAGE_CRAWL_PREDICATES)
+	var check func(page_type reflect.Type)
+	check = func(page_type reflect.Type) {
+		for i := 0; i < page_type.NumField(); i++ {
+			field := page_type.Field(i)
+			if field.Anonymous {
+				check(field.Type)
+				continue
+			}
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "" || name == "-" || strings.Contains(name, "|") || NOT_CRAWL_PREDICATES[name] {
+				continue
+			}
+			if !listed[name] {
+				t.Errorf("%s isn't in PAGE_CRAWL_PREDICATES", name)
+			}
+		}
+	}
+	check(reflect.TypeOf(Page{}))
+}
+
+// A noindex page still has its title and meta tags read, only its text is
+// left out. Its links are marked nofollow along with the page
+func TestCrawlPageRobots(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Robots-Tag", "noindex, nofollow")
+		w.Write([]byte(`<html><head><title>Private</title><meta name="description" content="Members only"></head>
+			<body><h1>One</h1><h1>Two</h1><img src="a.png"><p>Secret text</p><a href="/next">Next</a></body></html>`))
+	}))
+	defer server.Close()
+
+	spider := &Spider{logger: log.Default()}
+	page := &Page{URL: server.URL + "/"}
+	links := spider.crawl_page(page)
+
+	if !page.Noindex || !page.Nofollow {
+		t.Errorf("noindex %v, nofollow %v, want both", page.Noindex, page.Nofollow)
+	}
+	if page.Title != "Private" || page.Description != "Members only" || page.H1_count != 2 || page.Missing_alt != 1 {
+		t.Errorf("title %q, description %q, h1 %d, missing alt %d", page.Title, page.Description, page.H1_count, page.Missing_alt)
+	}
+	if page.Text != "" || page.needs_analysis {
+		t.Errorf("text %q read from a noindex page", page.Text)
+	}
+	if link, ok := links[server.URL+"/next"]; !ok || !link.Link_nofollow {
+		t.Errorf("link to /next %+v, want it marked nofollow", link)
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/json"
+	url_operations "net/url"
+	"sort"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// A Schema_entity is a schema.org thing a page describes, from JSON-LD or
+// microdata. Its dgraph.type holds both Schema_entity and the schema.org type
+type Schema_entity struct {
+	UID                string   `json:"uid,omitempty"`
+	Entity_id          string   `json:"entity_id,omitempty"`
+	Schema_type        string   `json:"schema_type,omitempty"`
+	Entity_name        string   `json:"entity_name,omitempty"`
+	Entity_url         URL      `json:"entity_url,omitempty"`
+	Entity_description string   `json:"entity_description,omitempty"`
+	Entity_image       URL      `json:"entity_image,omitempty"`
+	Schema_json        string   `json:"schema_json,omitempty"`
+	DType              []string `json:"dgraph.type,omitempty"`
+}
+
+// Open_graph_tags and Twitter_tags fields are stored straight on the Page
+type Open_graph_tags struct {
+	Og_title       string `json:"og_title,omitempty"`
+	Og_description string `json:"og_description,omitempty"`
+	Og_type        string `json:"og_type,omitempty"`
+	Og_image       URL    `json:"og_image,omitempty"`
+	Og_site_name   string `json:"og_site_name,omitempty"`
+	Og_url         URL    `json:"og_url,omitempty"`
+}
+
+type Twitter_tags struct {
+	Twitter_card        string `json:"twitter_card,omitempty"`
+	Twitter_title       string `json:"twitter_title,omitempty"`
+	Twitter_description string `json:"twitter_description,omitempty"`
+	Twitter_image       URL    `json:"twitter_image,omitempty"`
+	Twitter_site        string `json:"twitter_site,omitempty"`
+}
+
+// extract_metadata reads the meta description, OpenGraph and Twitter card
+// tags, JSON-LD blocks and microdata of a page
+func extract_metadata(doc *goquery.Document, page *Page) {
+	doc.Find("meta[content]").Each(func(i int, s *goquery.Selection) {
+		key, ok := s.Attr("property")
+		if !ok {
+			key, _ = s.Attr("name")
+		}
+		content, _ := s.Attr("content")
+		content = strings.TrimSpace(content)
+
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "description":
+			page.Description = content
+		case "og:title":
+			page.Og_title = content
+		case "og:description":
+			page.Og_description = content
+		case "og:type":
+			page.Og_type = content
+		case "og:image":
+			page.Og_image = content
+		case "og:site_name":
+			page.Og_site_name = content
+		case "og:url":
+			page.Og_url = content
+		case "twitter:card":
+			page.Twitter_card = content
+		case "twitter:title":
+			page.Twitter_title = content
+		case "twitter:description":
+			page.Twitter_description = content
+		case "twitter:image":
+			page.Twitter_image = content
+		case "twitter:site":
+			page.Twitter_site = content
+		}
+	})
+
+	page.Describes = append(extract_json_ld(doc, page.URL), extract_microdata(doc, page.URL)...)
+}
+
+// ## JSON-LD
+
+func extract_json_ld(doc *goquery.Document, page_url URL) []Schema_entity {
+	entities := []Schema_entity{}
+	doc.Find(`script[type="application/ld+json"]`).Each(func(i int, s *goquery.Selection) {
+		var data interface{}
+		if err := json.Unmarshal([]byte(s.Text()), &data); err != nil {
+			return
+		}
+		for _, object := range json_ld_objects(data) {
+			entity, ok := json_ld_entity(object, page_url)
+			if ok {
+				entities = append(entities, entity)
+			}
+		}
+	})
+	return entities
+}
+
+// json_ld_objects flattens arrays, @graph containers and nested objects,
+// such as an article's publisher or author, into the typed objects they hold
+func json_ld_objects(data interface{}) []map[string]interface{} {
+	objects := []map[string]interface{}{}
+	switch value := data.(type) {
+	case []interface{}:
+		for _, item := range value {
+			objects = append(objects, json_ld_objects(item)...)
+		}
+	case map[string]interface{}:
+		if _, ok := value["@type"]; ok {
+			objects = append(objects, value)
+		}
+		// Sorted so entities come out in the same order every time
+		keys := []string{}
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if key != "@context" {
+				objects = append(objects, json_ld_objects(value[key])...)
+			}
+		}
+	}
+	return objects
+}
+
+func json_ld_entity(object map[string]interface{}, page_url URL) (Schema_entity, bool) {
+	schema_type := json_ld_string(object["@type"])
+	if schema_type == "" {
+		return Schema_entity{}, false
+	}
+
+	raw, err := json.Marshal(object)
+	if err != nil {
+		return Schema_entity{}, false
+	}
+
+	entity := Schema_entity{
+		Schema_type:        schema_type,
+		Entity_name:        json_ld_string(object["name"]),
+		Entity_url:         resolve_reference(json_ld_string(object["url"]), page_url),
+		Entity_description: json_ld_string(object["description"]),
+		Entity_image:       json_ld_string(object["image"]),
+		Schema_json:        string(raw),
+	}
+	if entity.Entity_name == "" {
+		entity.Entity_name = json_ld_string(object["headline"])
+	}
+	entity.Entity_id = schema_entity_id(json_ld_string(object["@id"]), entity, page_url)
+	return entity, true
+}
+
+// json_ld_string reads a value that may be a string, a list or an object
+// with a url, as images and types often are
+func json_ld_string(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return strings.TrimSpace(value)
+	case []interface{}:
+		if len(value) > 0 {
+			return json_ld_string(value[0])
+		}
+	case map[string]interface{}:
+		if url, ok := value["url"]; ok {
+			return json_ld_string(url)
+		}
+		return json_ld_string(value["@id"])
+	}
+	return ""
+}
+
+// ## Microdata
+
+func extract_microdata(doc *goquery.Document, page_url URL) []Schema_entity {
+	entities := []Schema_entity{}
+	doc.Find("[itemscope][itemtype]").Each(func(i int, scope *goquery.Selection) {
+		itemtype, _ := scope.Attr("itemtype")
+		fields := strings.Fields(itemtype)
+		if len(fields) == 0 {
+			return
+		}
+		schema_type := fields[0][strings.LastIndex(fields[0], "/")+1:]
+
+		properties := make(map[string]string)
+		scope.Find("[itemprop]").Each(func(i int, s *goquery.Selection) {
+			// Properties of nested items belong to them
+			if owner := s.Parent().Closest("[itemscope]"); owner.Length() == 0 || owner.Get(0) != scope.Get(0) {
+				return
+			}
+			name, _ := s.Attr("itemprop")
+			if _, ok := properties[name]; !ok {
+				properties[name] = microdata_value(s)
+			}
+		})
+
+		raw, err := json.Marshal(properties)
+		if err != nil {
+			return
+		}
+		entity := Schema_entity{
+			Schema_type:        schema_type,
+			Entity_name:        properties["name"],
+			Entity_url:         resolve_reference(properties["url"], page_url),
+			Entity_description: properties["description"],
+			Entity_image:       properties["image"],
+			Schema_json:        string(raw),
+		}
+		if entity.Entity_name == "" {
+			entity.Entity_name = properties["headline"]
+		}
+		itemid, _ := scope.Attr("itemid")
+		entity.Entity_id = schema_entity_id(itemid, entity, page_url)
+		entities = append(entities, entity)
+	})
+	return entities
+}
+
+func microdata_value(s *goquery.Selection) string {
+	for _, attribute := range []string{"content", "href", "src", "datetime"} {
+		if value, ok := s.Attr(attribute); ok {
+			return strings.TrimSpace(value)
+		}
+	}
+	return strings.Join(strings.Fields(s.Text()), " ")
+}
+
+// schema_entity_id identifies an entity across pages so it's stored once.
+// Ids are resolved against the page, so "#organization" on two sites are two
+// entities, and names only identify an entity within the page's domain, two
+// sites' "John Smith" aren't the same person. Entities with nothing to
+// identify them by get no id and aren't shared
+func schema_entity_id(id string, entity Schema_entity, page_url URL) string {
+	switch {
+	case strings.HasPrefix(id, "_:"):
+		// Blank node ids only mean something inside their document
+		return page_url + " " + id
+	case id != "":
+		return resolve_reference(id, page_url)
+	case entity.Entity_url != "":
+		return entity.Schema_type + " " + entity.Entity_url
+	case entity.Entity_name != "":
+		domain, err := domain_name(page_url)
+		if err != nil {
+			return ""
+		}
+		return entity.Schema_type + " " + domain + " " + entity.Entity_name
+	}
+	return ""
+}
+
+// resolve_reference makes a url or IRI absolute against the page, values
+// that don't parse are kept as they are
+func resolve_reference(reference string, page_url URL) string {
+	if reference == "" {
+		return ""
+	}
+	base, err := url_operations.Parse(page_url)
+	if err != nil {
+		return reference
+	}
+	parsed, err := url_operations.Parse(reference)
+	if err != nil {
+		return reference
+	}
+	return base.ResolveReference(parsed).String()
+}
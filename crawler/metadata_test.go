@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestExtractMetadata(t *testing.T) {
+	doc := parse_html(t, `<html><head>
+		<meta name="description" content=" A page ">
+		<meta property="og:title" content="OG title">
+		<meta property="og:image" content="https://a.com/og.png">
+		<meta name="twitter:card" content="summary">
+		<meta name="twitter:site" content="@a">
+	</head></html>`)
+	page := &Page{URL: "https://a.com/post"}
+	extract_metadata(doc, page)
+	if page.Description != "A page" || page.Og_title != "OG title" || page.Og_image != "https://a.com/og.png" || page.Twitter_card != "summary" || page.Twitter_site != "@a" {
+		t.Errorf("got %q %+v %+v", page.Description, page.Open_graph_tags, page.Twitter_tags)
+	}
+}
+
+func TestExtractJsonLd(t *testing.T) {
+	doc := parse_html(t, `<html><head><script type="application/ld+json">
+		{"@context": "https://schema.org", "@graph": [
+			{"@type": "Article", "@id": "#article", "headline": "Crawling the web", "url": "/post",
+			 "author": {"@type": "Person", "name": "John Smith"},
+			 "publisher": {"@type": "Organization", "name": "A Inc", "url": "https://a.com", "logo": {"@type": "ImageObject", "url": "/logo.png"}}},
+			{"@type": "WebSite", "@id": "_:site", "name": "A"}
+		]}
+	</script><script type="application/ld+json">not json</script></head></html>`)
+
+	want := map[string]string{
+		"Article":      "https://a.com/post#article",
+		"Person":       "Person a.com John Smith",
+		"Organization": "Organization https://a.com",
+		"ImageObject":  "ImageObject https://a.com/logo.png",
+		"WebSite":      "https://a.com/post _:site",
+	}
+	entities := extract_json_ld(doc, "https://a.com/post")
+	if len(entities) != len(want) {
+		t.Errorf("%d entities, want %d: %+v", len(entities), len(want), entities)
+	}
+	for _, entity := range entities {
+		if entity.Entity_id != want[entity.Schema_type] {
+			t.Errorf("%s: id %q, want %q", entity.Schema_type, entity.Entity_id, want[entity.Schema_type])
+		}
+		if entity.Schema_type == "Article" && (entity.Entity_name != "Crawling the web" || entity.Entity_url != "https://a.com/post") {
+			t.Errorf("article %+v", entity)
+		}
+	}
+}
+
+func TestExtractMicrodata(t *testing.T) {
+	doc := parse_html(t, `<html><body>
+		<div itemscope itemtype="https://schema.org/Product" itemid="#product">
+			<span itemprop="name">Widget</span>
+			<a itemprop="url" href="/widget">Widget</a>
+			<div itemprop="brand" itemscope itemtype="https://schema.org/Brand"><span itemprop="name">Acme</span></div>
+		</div>
+	</body></html>`)
+	entities := extract_microdata(doc, "https://a.com/shop")
+	if len(entities) != 2 {
+		t.Fatalf("%d entities, want the product and its brand", len(entities))
+	}
+	product, brand := entities[0], entities[1]
+	if product.Schema_type != "Product" || product.Entity_name != "Widget" || product.Entity_url != "https://a.com/widget" || product.Entity_id != "https://a.com/shop#product" {
+		t.Errorf("product %+v", product)
+	}
+	if brand.Entity_name != "Acme" || brand.Entity_id != "Brand a.com Acme" {
+		t.Errorf("brand %+v", brand)
+	}
+}
+
+func TestSchemaEntityId(t *testing.T) {
+	tests := []struct {
+		id       string
+		entity   Schema_entity
+		page_url URL
+		want     string
+	}{
+		{"https://a.com/#org", Schema_entity{}, "https://a.com/about", "https://a.com/#org"},
+		{"#org", Schema_entity{}, "https://b.org/about", "https://b.org/about#org"},
+		{"_:b0", Schema_entity{}, "https://a.com/x", "https://a.com/x _:b0"},
+		{"", Schema_entity{Schema_type: "Organization", Entity_url: "https://a.com"}, "https://b.org/", "Organization https://a.com"},
+		{"", Schema_entity{Schema_type: "Person", Entity_name: "John Smith"}, "https://blog.a.com/x", "Person a.com John Smith"},
+		{"", Schema_entity{Schema_type: "Person", Entity_name: "John Smith"}, "https://b.org/x", "Person b.org John Smith"},
+		{"", Schema_entity{Schema_type: "Thing"}, "https://a.com/", ""},
+	}
+	for _, test := range tests {
+		if got := schema_entity_id(test.id, test.entity, test.page_url); got != test.want {
+			t.Errorf("schema_entity_id(%q, %+v, %s) = %q, want %q", test.id, test.entity, test.page_url, got, test.want)
+		}
+	}
+}
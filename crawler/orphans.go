@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/jedib0t/go-pretty/v6/table"
+)
+
+// Ways a page can be hard to reach, in the order they're reported
+const REACH_ORPHAN = "orphan"                         // Listed, but nothing links to it
+const REACH_EXTERNAL_ONLY = "external links only"     // Only other sites link to it
+const REACH_UNREACHABLE = "unreachable from the seed" // Only pages the seed can't reach link to it
+const REACH_DEEP = "deep"                             // More than STATS_BURIED_DEPTH clicks from the seed
+
+var REACH_PROBLEMS = []string{REACH_ORPHAN, REACH_EXTERNAL_ONLY, REACH_UNREACHABLE, REACH_DEEP}
+
+// An Inlinked_page is a stored page of the site with the crawled pages
+// linking to it
+type Inlinked_page struct {
+	URL         URL   `json:"url"`
+	Sitemaps    []URL `json:"sitemaps"`
+	Linked_from []struct {
+		URL       URL    `json:"url"`
+		Link_kind string `json:"~related_pages|kind"`
+		Domain    Domain `json:"domain"`
+	} `json:"~related_pages"`
+}
+
+type Reachability struct {
+	url            URL
+	problem        string
+	listed_in      []string
+	internal_links int
+	external_links int
+	click_depth    int
+}
+
+// orphans_command compares the pages the site lists, in its sitemaps and
+// the given seed lists, with the pages links from the seed reach
+func orphans_command(args []string) {
+	if len(args) < 1 {
+		log.Fatal(USAGE)
+	}
+	domain, err := domain_name(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	seed_lists := read_seed_lists(args[1:])
+
+	dg := Db_connect()
+	graph := Db_load_link_graph(dg, domain)
+	seed := seed_node(graph, args[0])
+	if seed < 0 {
+		log.Warn("seed isn't a crawled page, click depths are left out", "seed", args[0])
+	}
+	pages := Db_load_inlinked_pages(dg, domain)
+
+	report := page_reachability(graph, seed, domain, pages, seed_lists)
+	display_reachability(report, pages, seed_lists, domain)
+}
+
+// read_seed_lists reads files of urls, one per line, and returns each url
+// with the files listing it
+func read_seed_lists(files []string) map[URL][]string {
+	listed := make(map[URL][]string)
+	for _, name := range files {
+		file, err := os.Open(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			if url, ok := validate_url(line, line); ok {
+				listed[url] = append(listed[url], name)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			log.Fatal(err)
+		}
+		file.Close()
+	}
+	return listed
+}
+
+// page_reachability finds the listed or linked pages of the site that links
+// from the seed don't reach, or reach only after many clicks
+func page_reachability(graph *Link_graph, seed int, domain string, pages []Inlinked_page, seed_lists map[URL][]string) []Reachability {
+	depths, _ := shortest_paths(graph, seed)
+
+	report := []Reachability{}
+	seen := make(map[URL]bool)
+	check := func(url URL, sitemaps []URL, internal int, external int, click_depth int) {
+		seen[url] = true
+		if i, ok := graph.nodes[url]; ok && i == seed {
+			return
+		}
+
+		page := Reachability{url: url, internal_links: internal, external_links: external, click_depth: click_depth}
+		page.listed_in = append(append(page.listed_in, sitemaps...), seed_lists[url]...)
+
+		switch {
+		case internal == 0 && external == 0:
+			// Unlisted pages nothing links to are leftovers of older crawls
+			if len(page.listed_in) == 0 {
+				return
+			}
+			page.problem = REACH_ORPHAN
+		case internal == 0:
+			page.problem = REACH_EXTERNAL_ONLY
+		case seed >= 0 && page.click_depth < 0:
+			page.problem = REACH_UNREACHABLE
+		case page.click_depth > STATS_BURIED_DEPTH:
+			page.problem = REACH_DEEP
+		default:
+			return
+		}
+		report = append(report, page)
+	}
+
+	for _, page := range pages {
+		internal, external := 0, 0
+		// Pages never crawled aren't in the graph, they're a click past the
+		// closest page linking to them
+		click_depth := -1
+		if i, ok := graph.nodes[page.URL]; ok {
+			click_depth = depths[i]
+		}
+		for _, source := range page.Linked_from {
+			// Pages stored before link kinds were recorded have none
+			if source.Link_kind != "" && !CRAWLED_LINK_KINDS[source.Link_kind] {
+				continue
+			}
+			if source.Domain.Name != domain {
+				external += 1
+				continue
+			}
+			internal += 1
+			if _, ok := graph.nodes[page.URL]; ok {
+				continue
+			}
+			if i, ok := graph.nodes[source.URL]; ok && depths[i] >= 0 && (click_depth < 0 || depths[i]+1 < click_depth) {
+				click_depth = depths[i] + 1
+			}
+		}
+		check(page.URL, page.Sitemaps, internal, external, click_depth)
+	}
+	// Listed pages never stored have no links at all
+	for url := range seed_lists {
+		if !seen[url] {
+			check(url, nil, 0, 0, -1)
+		}
+	}
+
+	order := make(map[string]int)
+	for i, problem := range REACH_PROBLEMS {
+		order[problem] = i
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].problem != report[j].problem {
+			return order[report[i].problem] < order[report[j].problem]
+		}
+		return report[i].url < report[j].url
+	})
+	return report
+}
+
+func display_reachability(report []Reachability, pages []Inlinked_page, seed_lists map[URL][]string, domain string) {
+	in_sitemaps := 0
+	for _, page := range pages {
+		if len(page.Sitemaps) > 0 {
+			in_sitemaps += 1
+		}
+	}
+	counts := make(map[string]int)
+	for _, page := range report {
+		counts[page.problem] += 1
+	}
+
+	summary := table.NewWriter()
+	summary.SetOutputMirror(os.Stdout)
+	summary.AppendRows([]table.Row{
+		{"Pages stored", len(pages)},
+		{"Listed in sitemaps", in_sitemaps},
+		{"Listed in seed lists", len(seed_lists)},
+	})
+	summary.AppendSeparator()
+	for _, problem := range REACH_PROBLEMS {
+		summary.AppendRow(table.Row{strings.ToUpper(problem[:1]) + problem[1:], counts[problem]})
+	}
+	summary.SetTitle("Reachability of " + domain)
+	summary.Render()
+
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"Problem", "Page", "Internal links", "External links", "Click depth", "Listed in"})
+	for _, page := range report {
+		depth := "-"
+		if page.click_depth >= 0 {
+			depth = strconv.Itoa(page.click_depth)
+		}
+		t.AppendRow(table.Row{page.problem, page.url, page.internal_links, page.external_links, depth, strings.Join(page.listed_in, "\n")})
+	}
+	t.SetColumnConfigs([]table.ColumnConfig{{Number: 1, AutoMerge: true}})
+	t.SetTitle("Hard to reach pages")
+	t.Render()
+}
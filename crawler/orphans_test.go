@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// ORPHANS_TEST_PAGES are stored pages of a.com as Db_load_inlinked_pages
+// reads them. The seed links to /docs, /docs to /guide which is never
+// crawled, /island is only linked from /lost, which nothing links to
+const ORPHANS_TEST_PAGES = `[
+	{"url": "https://a.com", "~related_pages": [{"url": "https://a.com/docs", "~related_pages|kind": "navigation", "domain": {"name": "a.com"}}]},
+	{"url": "https://a.com/docs", "sitemaps": ["https://a.com/sitemap.xml"], "~related_pages": [{"url": "https://a.com", "~related_pages|kind": "navigation", "domain": {"name": "a.com"}}]},
+	{"url": "https://a.com/guide", "~related_pages": [{"url": "https://a.com/docs", "~related_pages|kind": "navigation", "domain": {"name": "a.com"}}]},
+	{"url": "https://a.com/orphan", "sitemaps": ["https://a.com/sitemap.xml"]},
+	{"url": "https://a.com/leftover"},
+	{"url": "https://a.com/press", "~related_pages": [{"url": "https://b.org", "~related_pages|kind": "navigation", "domain": {"name": "b.org"}}]},
+	{"url": "https://a.com/logo-only", "sitemaps": ["https://a.com/sitemap.xml"], "~related_pages": [{"url": "https://a.com", "~related_pages|kind": "resource", "domain": {"name": "a.com"}}]},
+	{"url": "https://a.com/lost"},
+	{"url": "https://a.com/island", "~related_pages": [{"url": "https://a.com/lost", "~related_pages|kind": "navigation", "domain": {"name": "a.com"}}]}
+]`
+
+func TestPageReachability(t *testing.T) {
+	var pages []Inlinked_page
+	if err := json.Unmarshal([]byte(ORPHANS_TEST_PAGES), &pages); err != nil {
+		t.Fatal(err)
+	}
+	graph := test_graph(
+		[]URL{"https://a.com", "https://a.com/docs", "https://a.com/lost", "https://a.com/island"},
+		[][2]URL{{"https://a.com", "https://a.com/docs"}, {"https://a.com/docs", "https://a.com"}, {"https://a.com/lost", "https://a.com/island"}},
+	)
+	seed_lists := map[URL][]string{"https://a.com/unstored": {"urls.txt"}, "https://a.com/docs": {"urls.txt"}}
+
+	report := page_reachability(graph, 0, "a.com", pages, seed_lists)
+	got := map[URL]string{}
+	for _, page := range report {
+		got[page.url] = page.problem
+	}
+	want := map[URL]string{
+		"https://a.com/orphan":    REACH_ORPHAN,
+		"https://a.com/logo-only": REACH_ORPHAN,
+		"https://a.com/unstored":  REACH_ORPHAN,
+		"https://a.com/press":     REACH_EXTERNAL_ONLY,
+		"https://a.com/island":    REACH_UNREACHABLE,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for i := 1; i < len(report); i++ {
+		if report[i-1].problem == report[i].problem && report[i-1].url > report[i].url {
+			t.Errorf("report not sorted: %s before %s", report[i-1].url, report[i].url)
+		}
+	}
+	for _, page := range report {
+		if page.url == "https://a.com/unstored" && !reflect.DeepEqual(page.listed_in, []string{"urls.txt"}) {
+			t.Errorf("unstored page listed in %v", page.listed_in)
+		}
+	}
+}
+
+// A page never crawled sits a click past its closest crawled linker
+func TestPageReachabilityDepth(t *testing.T) {
+	urls := []URL{"https://a.com"}
+	links := [][2]URL{}
+	for i := 1; i <= STATS_BURIED_DEPTH; i++ {
+		urls = append(urls, "https://a.com/"+string(rune('a'+i)))
+		links = append(links, [2]URL{urls[i-1], urls[i]})
+	}
+	graph := test_graph(urls, links)
+	deepest := urls[len(urls)-1]
+	pages := []Inlinked_page{{URL: "https://a.com/uncrawled"}}
+	pages[0].Linked_from = append(pages[0].Linked_from, struct {
+		URL       URL    `json:"url"`
+		Link_kind string `json:"~related_pages|kind"`
+		Domain    Domain `json:"domain"`
+	}{URL: deepest, Link_kind: LINK_NAVIGATION, Domain: Domain{Name: "a.com"}})
+
+	report := page_reachability(graph, 0, "a.com", pages, nil)
+	if len(report) != 1 || report[0].problem != REACH_DEEP || report[0].click_depth != STATS_BURIED_DEPTH+1 {
+		t.Errorf("got %+v, want the uncrawled page %d clicks deep", report, STATS_BURIED_DEPTH+1)
+	}
+}
+
+func TestReadSeedLists(t *testing.T) {
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "first.txt"), filepath.Join(dir, "second.txt")
+	os.WriteFile(first, []byte("# comment\nhttps://a.com/docs/\n\nhttps://a.com/about\n"), 0o644)
+	os.WriteFile(second, []byte("https://a.com/docs\n"), 0o644)
+
+	want := map[URL][]string{
+		"https://a.com/docs":  {first, second},
+		"https://a.com/about": {first},
+	}
+	if got := read_seed_lists([]string{first, second}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
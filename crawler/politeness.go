@@ -0,0 +1,44 @@
+package main
+
+import (
+	url_operations "net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Host_limiter spaces out requests to the same host by a delay, shared by
+// the spiders and the link checker so neither hammers a site
+type Host_limiter struct {
+	delay time.Duration
+	lock  sync.Mutex
+	// When each host may be asked next
+	next map[string]time.Time
+}
+
+var host_limiter = new_host_limiter(HOST_REQUEST_DELAY)
+
+func new_host_limiter(delay time.Duration) *Host_limiter {
+	return &Host_limiter{delay: delay, next: make(map[string]time.Time)}
+}
+
+// wait blocks until the url's host may be asked again and books the slot,
+// so concurrent callers queue up instead of all going at once
+func (limiter *Host_limiter) wait(url URL) {
+	parsed_url, err := url_operations.Parse(url)
+	if err != nil || parsed_url.Host == "" || limiter.delay <= 0 {
+		return
+	}
+	host := strings.ToLower(parsed_url.Host)
+
+	limiter.lock.Lock()
+	now := time.Now()
+	slot := limiter.next[host]
+	if slot.Before(now) {
+		slot = now
+	}
+	limiter.next[host] = slot.Add(limiter.delay)
+	limiter.lock.Unlock()
+
+	time.Sleep(time.Until(slot))
+}
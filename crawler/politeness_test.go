@@ -0,0 +1,23 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHostLimiter(t *testing.T) {
+	const delay = 20 * time.Millisecond
+	limiter := new_host_limiter(delay)
+
+	start := time.Now()
+	limiter.wait("https://a.com/1")
+	limiter.wait("https://b.org/1")
+	if elapsed := time.Since(start); elapsed > delay/2 {
+		t.Errorf("first requests to two hosts waited %v", elapsed)
+	}
+	limiter.wait("https://A.com/2")
+	limiter.wait("https://a.com/3")
+	if elapsed := time.Since(start); elapsed < 2*delay-delay/4 {
+		t.Errorf("three requests to one host took %v, want %v apart", elapsed, delay)
+	}
+}
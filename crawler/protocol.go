@@ -0,0 +1,100 @@
+package main
+
+import "time"
+
+// The analyzer protocol, spoken over HTTP+JSON and gRPC by every analyzer
+// service. See docs/analyzer.md for the endpoints
+const PROTOCOL_VERSION = "v1"
+
+// Tasks a service can be asked for
+const TASK_SUMMARY = "summary"
+const TASK_KEYWORDS = "keywords"
+const TASK_ENTITIES = "entities"
+const TASK_EMBEDDING = "embedding"
+
+// Error codes a service can answer with
+const ERROR_BAD_REQUEST = "bad_request"
+const ERROR_UNSUPPORTED_VERSION = "unsupported_version"
+const ERROR_UNSUPPORTED_TASK = "unsupported_task"
+const ERROR_UNSUPPORTED_LANGUAGE = "unsupported_language"
+const ERROR_BATCH_TOO_LARGE = "batch_too_large"
+const ERROR_INTERNAL = "internal"
+
+type Analysis_request struct {
+	Version   string              `json:"version"`
+	Tasks     []string            `json:"tasks"`
+	Documents []Protocol_document `json:"documents"`
+}
+
+// timeout is how long a service may take to answer the request
+func (request Analysis_request) timeout() time.Duration {
+	return ANALYZER_TIMEOUT + time.Duration(len(request.Documents))*ANALYZER_DOCUMENT_TIMEOUT
+}
+
+type Protocol_document struct {
+	ID  string `json:"id"`
+	URL URL    `json:"url,omitempty"`
+	// An ISO 639-1 code, empty if unknown
+	Language string `json:"language,omitempty"`
+	Text     string `json:"text"`
+}
+
+type Analysis_response struct {
+	Version string            `json:"version"`
+	Results []Protocol_result `json:"results"`
+	// Set when the whole request failed
+	Error *Protocol_error `json:"error,omitempty"`
+}
+
+// A Protocol_result answers the document with the same id, a document that
+// failed has Error set and nothing else
+type Protocol_result struct {
+	ID        string             `json:"id"`
+	Summary   string             `json:"summary,omitempty"`
+	Keywords  []Protocol_keyword `json:"keywords,omitempty"`
+	Entities  []Protocol_entity  `json:"entities,omitempty"`
+	Embedding []float64          `json:"embedding,omitempty"`
+	Error     *Protocol_error    `json:"error,omitempty"`
+}
+
+type Protocol_keyword struct {
+	Term  string  `json:"term"`
+	Score float64 `json:"score,omitempty"`
+}
+
+// Kind is one of person, organization, place or product
+type Protocol_entity struct {
+	Name  string `json:"name"`
+	Kind  string `json:"kind"`
+	Count int    `json:"count,omitempty"`
+}
+
+type Protocol_error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (err *Protocol_error) Error() string {
+	return err.Code + ": " + err.Message
+}
+
+// Capabilities are what a service can do, served for discovery
+type Capabilities struct {
+	Version string `json:"version"`
+	Name    string `json:"name"`
+	// Changes whenever the service's results would, e.g. a new model
+	Analyzer_version string   `json:"analyzer_version"`
+	Tasks            []string `json:"tasks"`
+	// Empty if every language is accepted
+	Languages      []string `json:"languages,omitempty"`
+	Max_batch_size int      `json:"max_batch_size"`
+}
+
+func (capabilities *Capabilities) supports_task(task string) bool {
+	for _, supported := range capabilities.Tasks {
+		if supported == task {
+			return true
+		}
+	}
+	return false
+}
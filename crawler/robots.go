@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Robots directives config
+// When false the directives are only recorded on pages and edges
+const OBEY_ROBOTS_DIRECTIVES = true
+const ROBOTS_USER_AGENT = "gopher-crawler"
+
+type Robots_directives struct {
+	noindex  bool
+	nofollow bool
+}
+
+// get_robots_directives reads <meta name="robots"> and the X-Robots-Tag
+// header. Directives scoped to another user agent are ignored
+func get_robots_directives(doc *goquery.Document, header http.Header) Robots_directives {
+	directives := Robots_directives{}
+
+	doc.Find("meta[name]").Each(func(i int, s *goquery.Selection) {
+		name, _ := s.Attr("name")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "robots" && name != ROBOTS_USER_AGENT {
+			return
+		}
+		content, _ := s.Attr("content")
+		directives.add(content)
+	})
+
+	for _, value := range header.Values("X-Robots-Tag") {
+		// The header may be prefixed with a user agent, e.g. "googlebot: noindex"
+		if agent, rest, ok := strings.Cut(value, ":"); ok && !is_robots_directive(agent) {
+			agent = strings.ToLower(strings.TrimSpace(agent))
+			if agent != ROBOTS_USER_AGENT && agent != "*" {
+				continue
+			}
+			value = rest
+		}
+		directives.add(value)
+	}
+
+	return directives
+}
+
+func (directives *Robots_directives) add(content string) {
+	for _, directive := range strings.Split(content, ",") {
+		switch strings.ToLower(strings.TrimSpace(directive)) {
+		case "noindex":
+			directives.noindex = true
+		case "nofollow":
+			directives.nofollow = true
+		case "none":
+			directives.noindex = true
+			directives.nofollow = true
+		}
+	}
+}
+
+func is_robots_directive(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "all", "noindex", "nofollow", "none", "noarchive", "nosnippet", "noimageindex", "notranslate":
+		return true
+	}
+	return false
+}
+
+// has_rel reports whether a space separated rel attribute contains value
+func has_rel(rel string, value string) bool {
+	for _, r := range strings.Fields(strings.ToLower(rel)) {
+		if r == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// parse_html makes a document out of test markup
+func parse_html(t *testing.T, html string) *goquery.Document {
+	t.Helper()
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestGetRobotsDirectives(t *testing.T) {
+	tests := []struct {
+		name     string
+		meta     string
+		header   []string
+		noindex  bool
+		nofollow bool
+	}{
+		{"none given", "", nil, false, false},
+		{"meta noindex", `<meta name="robots" content="noindex">`, nil, true, false},
+		{"meta both, spaced and cased", `<meta name="ROBOTS" content=" NoIndex , nofollow ">`, nil, true, true},
+		{"meta none", `<meta name="robots" content="none">`, nil, true, true},
+		{"meta for this crawler", `<meta name="gopher-crawler" content="nofollow">`, nil, false, true},
+		{"meta for another crawler", `<meta name="googlebot" content="noindex">`, nil, false, false},
+		{"header", "", []string{"noindex, nofollow"}, true, true},
+		{"header for another agent", "", []string{"googlebot: noindex"}, false, false},
+		{"header for every agent", "", []string{"*: nofollow"}, false, true},
+		{"header for this crawler", "", []string{"gopher-crawler: noindex"}, true, false},
+		{"header and meta add up", `<meta name="robots" content="noindex">`, []string{"nofollow"}, true, true},
+		{"all allows everything", `<meta name="robots" content="all">`, []string{"all"}, false, false},
+	}
+	for _, test := range tests {
+		header := http.Header{}
+		for _, value := range test.header {
+			header.Add("X-Robots-Tag", value)
+		}
+		directives := get_robots_directives(parse_html(t, "<html><head>"+test.meta+"</head></html>"), header)
+		if directives.noindex != test.noindex || directives.nofollow != test.nofollow {
+			t.Errorf("%s: noindex %v, nofollow %v, want %v, %v", test.name, directives.noindex, directives.nofollow, test.noindex, test.nofollow)
+		}
+	}
+}
+
+func TestHasRel(t *testing.T) {
+	tests := []struct {
+		rel   string
+		value string
+		want  bool
+	}{
+		{"nofollow", "nofollow", true},
+		{"noopener NOFOLLOW", "nofollow", true},
+		{"ugc sponsored", "nofollow", false},
+		{"nofollowing", "nofollow", false},
+		{"", "nofollow", false},
+	}
+	for _, test := range tests {
+		if got := has_rel(test.rel, test.value); got != test.want {
+			t.Errorf("has_rel(%q, %q) = %v, want %v", test.rel, test.value, got, test.want)
+		}
+	}
+}
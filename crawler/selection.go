@@ -0,0 +1,85 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// Link selection policies, pick which of a page's links are kept
+const POLICY_ALL = "all"                   // Keep every link
+const POLICY_MAIN_CONTENT = "main_content" // Keep the first N links outside nav, header, footer and aside
+const POLICY_RANDOM = "random"             // Keep a random sample of N links
+const POLICY_TOP_SCORE = "top_score"       // Keep the N best links by link_score
+
+// Link selection config
+const LINK_POLICY = POLICY_MAIN_CONTENT
+const LINK_POLICY_LIMIT = 30
+
+// select_links applies LINK_POLICY to the links of a page, in document
+// order. Returns the kept links and how many were discarded
+func select_links(links []Page) ([]Page, int) {
+	selected := links
+	switch LINK_POLICY {
+	case POLICY_ALL:
+	case POLICY_MAIN_CONTENT:
+		selected = []Page{}
+		for _, link := range links {
+			if link.Link_region == REGION_MAIN || link.Link_region == REGION_BODY {
+				selected = append(selected, link)
+			}
+		}
+		selected = first_n(selected, LINK_POLICY_LIMIT)
+	case POLICY_RANDOM:
+		selected = append([]Page{}, links...)
+		rand.Shuffle(len(selected), func(i, j int) {
+			selected[i], selected[j] = selected[j], selected[i]
+		})
+		selected = first_n(selected, LINK_POLICY_LIMIT)
+	case POLICY_TOP_SCORE:
+		selected = append([]Page{}, links...)
+		sort.SliceStable(selected, func(i, j int) bool {
+			return link_score(selected[i]) > link_score(selected[j])
+		})
+		selected = first_n(selected, LINK_POLICY_LIMIT)
+	}
+	return selected, len(links) - len(selected)
+}
+
+func first_n(links []Page, n int) []Page {
+	if len(links) > n {
+		return links[:n]
+	}
+	return links
+}
+
+// link_score favours navigation links with descriptive anchor text in the
+// main content of a page
+func link_score(link Page) float64 {
+	score := 0.0
+
+	switch link.Link_region {
+	case REGION_MAIN:
+		score += 3
+	case REGION_BODY:
+		score += 2
+	case REGION_ASIDE:
+		score += 1
+	}
+
+	if link.Link_kind == LINK_NAVIGATION {
+		score += 1
+	}
+	if link.Link_nofollow {
+		score -= 1
+	}
+
+	// Up to one point for anchor text, "click here" is worth less than a title
+	words := len(strings.Fields(link.Anchor_text))
+	if words > 8 {
+		words = 8
+	}
+	score += float64(words) / 8
+
+	return score
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSelectLinks(t *testing.T) {
+	links := []Page{}
+	for i, region := range []string{REGION_NAV, REGION_MAIN, REGION_FOOTER, REGION_BODY, REGION_HEADER, REGION_MAIN, REGION_ASIDE} {
+		links = append(links, Page{URL: fmt.Sprint("https://a.com/", i), Link_facets: Link_facets{Link_region: region, Link_kind: LINK_NAVIGATION, Link_position: i + 1}})
+	}
+
+	selected, discarded := select_links(links)
+	urls := []URL{}
+	for _, link := range selected {
+		urls = append(urls, link.URL)
+	}
+	// main_content keeps main and body links in document order
+	if got := strings.Join(urls, " "); got != "https://a.com/1 https://a.com/3 https://a.com/5" || discarded != 4 {
+		t.Errorf("selected %s, discarded %d", got, discarded)
+	}
+}
+
+func TestLinkScore(t *testing.T) {
+	tests := []struct {
+		name   string
+		better Link_facets
+		worse  Link_facets
+	}{
+		{"main over nav", Link_facets{Link_region: REGION_MAIN}, Link_facets{Link_region: REGION_NAV}},
+		{"navigation over resource", Link_facets{Link_kind: LINK_NAVIGATION}, Link_facets{Link_kind: LINK_RESOURCE}},
+		{"followed over nofollow", Link_facets{}, Link_facets{Link_nofollow: true}},
+		{"descriptive anchor text", Link_facets{Anchor_text: "Pricing for small teams"}, Link_facets{Anchor_text: "here"}},
+	}
+	for _, test := range tests {
+		if link_score(Page{Link_facets: test.better}) <= link_score(Page{Link_facets: test.worse}) {
+			t.Errorf("%s: scored no higher", test.name)
+		}
+	}
+}
+
+// Images and scripts aren't crawled, they mustn't use up the policy's limit
+func TestFindRelatedPagesSelectsCrawlableLinks(t *testing.T) {
+	html := "<html><body><main>"
+	for i := 0; i < 40; i++ {
+		html += fmt.Sprintf(`<img src="/image/%d.png">`, i)
+	}
+	for i := 0; i < 10; i++ {
+		html += fmt.Sprintf(`<a href="/page/%d">Page %d</a>`, i, i)
+	}
+	html += `<a href="/private" rel="nofollow">Private</a><a href="/">Home</a><script src="/app.js"></script></main></body></html>`
+
+	page := &Page{URL: "https://a.com"}
+	links := find_related_pages(parse_html(t, html), page)
+
+	selected := 0
+	for _, link := range links {
+		if link.Link_selected {
+			selected += 1
+		}
+	}
+	if selected != 10 {
+		t.Errorf("%d links selected, want the 10 anchors", selected)
+	}
+	if len(links) != 10+40+3 {
+		t.Errorf("%d links kept, want every link as an edge", len(links))
+	}
+	if page.Links_discarded != 0 {
+		t.Errorf("%d links discarded, want 0", page.Links_discarded)
+	}
+}
+
+// Links over the limit are stored but not crawled
+func TestFindRelatedPagesKeepsUnselectedLinks(t *testing.T) {
+	html := "<html><body><main>"
+	for i := 0; i < LINK_POLICY_LIMIT+5; i++ {
+		html += fmt.Sprintf(`<a href="/page/%d">Page %d</a>`, i, i)
+	}
+	html += "</main></body></html>"
+
+	page := &Page{URL: "https://a.com"}
+	page.related_pages = find_related_pages(parse_html(t, html), page)
+	if len(page.related_pages) != LINK_POLICY_LIMIT+5 || page.Links_discarded != 5 {
+		t.Errorf("%d links kept and %d discarded, want every link kept and 5 discarded", len(page.related_pages), page.Links_discarded)
+	}
+
+	index := &Index{pages_to_crawl: make(chan Page, 100)}
+	spider := &Spider{}
+	spider.add_related_pages(page, index)
+	if len(index.pages_to_crawl) != LINK_POLICY_LIMIT {
+		t.Errorf("%d links queued, want the %d selected", len(index.pages_to_crawl), LINK_POLICY_LIMIT)
+	}
+	for len(index.pages_to_crawl) > 0 {
+		if link := <-index.pages_to_crawl; !link.Link_selected {
+			t.Errorf("queued %s, which wasn't selected", link.URL)
+		}
+	}
+}
+
+// A url linked several times keeps its best link
+func TestFindRelatedPagesBestLink(t *testing.T) {
+	html := `<html><body><nav><a href="/docs">Docs</a></nav><main><a href="/docs#start">Read the getting started guide</a></main></body></html>`
+	links := find_related_pages(parse_html(t, html), &Page{URL: "https://a.com"})
+	if docs := links["https://a.com/docs"]; docs.Link_region != REGION_MAIN || docs.Anchor_text != "Read the getting started guide" {
+		t.Errorf("kept %+v, want the link in the main content", docs.Link_facets)
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/charmbracelet/log"
+	"github.com/dgraph-io/dgo/v2"
+)
+
+// Backends building page vectors, for similarity and clustering
+const VECTORS_TFIDF = "tfidf"      // Stemmed TF-IDF vectors over the pages of the crawl, in Go
+const VECTORS_HASHED = "hashed"    // Hashed bag of words, a local stand-in for embeddings
+const VECTORS_HTTP = ANALYZER_HTTP // Embeddings from an analyzer service over HTTP
+const VECTORS_GRPC = ANALYZER_GRPC // Embeddings from an analyzer service over gRPC
+
+// Page vector config
+// Backend used once the crawl is over to build page vectors, empty to skip
+// linking similar pages and clustering
+const PAGE_VECTORS = VECTORS_TFIDF
+
+// Similarity config
+// Each page links to its SIMILARITY_TOP_K most similar pages above
+// SIMILARITY_MIN_SCORE, by cosine similarity. 0 to skip
+const SIMILARITY_TOP_K = 5
+const SIMILARITY_MIN_SCORE = 0.2
+
+const HASHED_VECTOR_SIZE = 512
+
+// A Vector is sparse, dense embeddings are keyed by their index
+type Vector map[string]float64
+
+// A Vectorizer turns every page of the crawl into a vector. It sees them
+// all at once, so it can weigh terms against the whole crawl
+type Vectorizer interface {
+	vectors(documents []Document) ([]Vector, error)
+}
+
+func new_vectorizer(name string) (Vectorizer, error) {
+	switch name {
+	case VECTORS_TFIDF:
+		return &Tfidf_vectorizer{}, nil
+	case VECTORS_HASHED:
+		return &Hashed_vectorizer{}, nil
+	case VECTORS_HTTP:
+		return &Embedding_vectorizer{analyzer: new_protocol_analyzer(VECTORS_HTTP, new_http_transport(ANALYZER_URL), []string{TASK_EMBEDDING})}, nil
+	case VECTORS_GRPC:
+		transport, err := new_grpc_transport(ANALYZER_GRPC_ADDRESS)
+		if err != nil {
+			return nil, err
+		}
+		return &Embedding_vectorizer{analyzer: new_protocol_analyzer(VECTORS_GRPC, transport, []string{TASK_EMBEDDING})}, nil
+	}
+	return nil, fmt.Errorf("unknown vectorizer %q", name)
+}
+
+// page_vectors builds a vector for each page of the crawl with text
+func page_vectors(index *Index) ([]Document, []Vector) {
+	if PAGE_VECTORS == "" {
+		return nil, nil
+	}
+	vectorizer, err := new_vectorizer(PAGE_VECTORS)
+	if err != nil {
+		log.Warn("could not build page vectors", "err", err)
+		return nil, nil
+	}
+
+	documents := []Document{}
+	index.lock.Lock()
+	for url, page := range index.inprogress_or_done_pages {
+		// Aliases share their canonical page
+		if page.URL != url || page.Text == "" {
+			continue
+		}
+		documents = append(documents, Document{URL: page.URL, Text: page.Text, Language: page.Language})
+	}
+	index.lock.Unlock()
+	// Map order would make clustering differ between runs of the same crawl
+	sort.Slice(documents, func(i, j int) bool {
+		return documents[i].URL < documents[j].URL
+	})
+
+	vectors, err := vectorizer.vectors(documents)
+	if err != nil {
+		log.Warn("could not build page vectors", "err", err)
+		return nil, nil
+	}
+	return documents, vectors
+}
+
+// link_similar_pages links each page to its most similar pages with
+// similar_to edges
+func link_similar_pages(documents []Document, vectors []Vector, dg *dgo.Dgraph) {
+	if SIMILARITY_TOP_K == 0 || len(documents) < 2 {
+		return
+	}
+
+	edges := 0
+	for i, neighbours := range nearest_neighbours(vectors, SIMILARITY_TOP_K, SIMILARITY_MIN_SCORE) {
+		similar := []Page{}
+		for _, neighbour := range neighbours {
+			similar = append(similar, Page{URL: documents[neighbour.index].URL, Link_facets: Link_facets{Similarity: neighbour.score}})
+		}
+		Db_set_similar_pages(dg, documents[i].URL, similar)
+		edges += len(similar)
+	}
+	log.Info("Linked similar pages", "pages", len(documents), "edges", edges)
+}
+
+type Neighbour struct {
+	index int
+	score float64
+}
+
+// nearest_neighbours finds the k most similar vectors to each vector. The
+// vectors must be normalized, dot products are found through an inverted
+// index so sparse vectors only meet the vectors they share a dimension with
+func nearest_neighbours(vectors []Vector, k int, min_score float64) [][]Neighbour {
+	postings := make(map[string][]int)
+	for i, vector := range vectors {
+		for dimension := range vector {
+			postings[dimension] = append(postings[dimension], i)
+		}
+	}
+
+	neighbours := make([][]Neighbour, len(vectors))
+	for i, vector := range vectors {
+		scores := make(map[int]float64)
+		for dimension, value := range vector {
+			for _, j := range postings[dimension] {
+				if j != i {
+					scores[j] += value * vectors[j][dimension]
+				}
+			}
+		}
+		for j, score := range scores {
+			if score >= min_score {
+				neighbours[i] = append(neighbours[i], Neighbour{index: j, score: score})
+			}
+		}
+		sort.Slice(neighbours[i], func(a, b int) bool {
+			if neighbours[i][a].score != neighbours[i][b].score {
+				return neighbours[i][a].score > neighbours[i][b].score
+			}
+			return neighbours[i][a].index < neighbours[i][b].index
+		})
+		if len(neighbours[i]) > k {
+			neighbours[i] = neighbours[i][:k]
+		}
+	}
+	return neighbours
+}
+
+func dot(a Vector, b Vector) float64 {
+	if len(b) < len(a) {
+		a, b = b, a
+	}
+	product := 0.0
+	for dimension, value := range a {
+		product += value * b[dimension]
+	}
+	return product
+}
+
+func normalize(vector Vector) Vector {
+	norm := 0.0
+	for _, value := range vector {
+		norm += value * value
+	}
+	norm = math.Sqrt(norm)
+	if norm == 0 {
+		return vector
+	}
+	for dimension := range vector {
+		vector[dimension] /= norm
+	}
+	return vector
+}
+
+// stemmed_terms counts the stemmed words of a document, without stopwords
+func stemmed_terms(document Document) Term_counts {
+	tokens := tokenize(document.Text)
+	language := document_language(document, tokens)
+	terms := Term_counts{counts: make(map[string]int)}
+	for _, token := range tokens {
+		if is_stopword(token, language) {
+			continue
+		}
+		terms.counts[stem(token, language)] += 1
+		terms.total += 1
+	}
+	return terms
+}
+
+// ## TF-IDF vectors
+
+type Tfidf_vectorizer struct{}
+
+func (vectorizer *Tfidf_vectorizer) vectors(documents []Document) ([]Vector, error) {
+	document_frequency := make(map[string]int)
+	terms := make([]Term_counts, len(documents))
+	for i, document := range documents {
+		terms[i] = stemmed_terms(document)
+		for term := range terms[i].counts {
+			document_frequency[term] += 1
+		}
+	}
+
+	vectors := make([]Vector, len(documents))
+	for i := range documents {
+		vectors[i] = make(Vector)
+		for term, count := range terms[i].counts {
+			idf := smoothed_idf(len(documents), document_frequency[term])
+			vectors[i][term] = float64(count) / float64(terms[i].total) * idf
+		}
+		normalize(vectors[i])
+	}
+	return vectors, nil
+}
+
+// ## Hashed vectors
+
+// Hashed_vectorizer hashes stemmed words into HASHED_VECTOR_SIZE buckets,
+// vectors of a fixed size without looking at the rest of the crawl
+type Hashed_vectorizer struct{}
+
+func (vectorizer *Hashed_vectorizer) vectors(documents []Document) ([]Vector, error) {
+	vectors := make([]Vector, len(documents))
+	for i, document := range documents {
+		vectors[i] = embedding_vector(hashed_embedding(document))
+	}
+	return vectors, nil
+}
+
+// hashed_embedding is a dense vector of HASHED_VECTOR_SIZE, the sign of a
+// word's bucket comes from the hash too so collisions tend to cancel out
+func hashed_embedding(document Document) []float64 {
+	embedding := make([]float64, HASHED_VECTOR_SIZE)
+	for term, count := range stemmed_terms(document).counts {
+		hash := fnv_hash(term)
+		sign := 1.0
+		if hash&(1<<63) != 0 {
+			sign = -1
+		}
+		embedding[hash%HASHED_VECTOR_SIZE] += sign * math.Log(1+float64(count))
+	}
+	return embedding
+}
+
+func embedding_vector(embedding []float64) Vector {
+	vector := make(Vector)
+	for i, value := range embedding {
+		if value != 0 {
+			vector[strconv.Itoa(i)] = value
+		}
+	}
+	return normalize(vector)
+}
+
+// ## Embeddings
+
+// Embedding_vectorizer asks an analyzer service for embeddings
+type Embedding_vectorizer struct {
+	analyzer *Protocol_analyzer
+}
+
+func (vectorizer *Embedding_vectorizer) vectors(documents []Document) ([]Vector, error) {
+	embeddings, errs := vectorizer.analyzer.embed(documents)
+	vectors := make([]Vector, len(documents))
+	for i := range documents {
+		if errs[i] != nil {
+			log.Warn("could not embed page", "URL", documents[i].URL, "err", errs[i])
+		}
+		vectors[i] = embedding_vector(embeddings[i])
+	}
+	return vectors, nil
+}
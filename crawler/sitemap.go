@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	url_operations "net/url"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/dgraph-io/dgo/v2"
+)
+
+// Sitemap config
+// Read the sitemaps named in robots.txt, or /sitemap.xml, once a crawl is over
+const SITEMAP_DISCOVERY = true
+
+// Sitemap indexes can nest, stop after this many sitemaps or urls
+const MAX_SITEMAPS = 50
+const MAX_SITEMAP_URLS = 50000
+
+// The protocol caps a sitemap at 50MB uncompressed
+const MAX_SITEMAP_SIZE = 50 << 20
+
+// Sitemap_file is either a <urlset> or a <sitemapindex>, only one of the
+// lists is filled
+type Sitemap_file struct {
+	URLs []struct {
+		Loc string `xml:"loc"`
+	} `xml:"url"`
+	Sitemaps []struct {
+		Loc string `xml:"loc"`
+	} `xml:"sitemap"`
+}
+
+// read_sitemaps finds the sitemaps of the seed's site and returns the pages
+// they list, each with the sitemaps listing it. Pages of other domains are
+// left out, the protocol doesn't allow them
+func read_sitemaps(seed URL) map[URL][]URL {
+	listed := make(map[URL][]URL)
+	domain, err := domain_name(seed)
+	if err != nil {
+		log.Warn("could not read sitemaps", "seed", seed, "err", err)
+		return listed
+	}
+
+	queue := find_sitemaps(seed)
+	read := make(map[URL]bool)
+	for len(queue) > 0 && len(read) < MAX_SITEMAPS && len(listed) < MAX_SITEMAP_URLS {
+		sitemap := queue[0]
+		queue = queue[1:]
+		if read[sitemap] {
+			continue
+		}
+		read[sitemap] = true
+
+		file, err := fetch_sitemap(sitemap)
+		if err != nil {
+			log.Warn("could not read sitemap", "sitemap", sitemap, "err", err)
+			continue
+		}
+		for _, entry := range file.Sitemaps {
+			if url, ok := validate_url(entry.Loc, sitemap); ok {
+				queue = append(queue, url)
+			}
+		}
+		for _, entry := range file.URLs {
+			url, ok := validate_url(entry.Loc, sitemap)
+			if !ok {
+				continue
+			}
+			if url_domain, err := domain_name(url); err != nil || url_domain != domain {
+				continue
+			}
+			if len(listed[url]) == 0 && len(listed) == MAX_SITEMAP_URLS {
+				break
+			}
+			listed[url] = append(listed[url], sitemap)
+		}
+		log.Info("Sitemap read", "sitemap", sitemap, "urls", len(file.URLs), "sitemaps", len(file.Sitemaps))
+	}
+	return listed
+}
+
+// find_sitemaps reads the Sitemap lines of the site's robots.txt, falling
+// back to /sitemap.xml when there are none
+func find_sitemaps(seed URL) []URL {
+	base, err := url_operations.Parse(seed)
+	if err != nil {
+		return nil
+	}
+	root := base.Scheme + "://" + base.Host
+	fallback := []URL{root + "/sitemap.xml"}
+
+	resp, err := fetch_client.Get(root + "/robots.txt")
+	if err != nil {
+		return fallback
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fallback
+	}
+
+	sitemaps := []URL{}
+	scanner := bufio.NewScanner(io.LimitReader(resp.Body, MAX_SITEMAP_SIZE))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok || !strings.EqualFold(strings.TrimSpace(key), "sitemap") {
+			continue
+		}
+		// Only the first colon is cut, the url keeps its own
+		if url, ok := validate_url(strings.TrimSpace(value), root); ok {
+			sitemaps = append(sitemaps, url)
+		}
+	}
+	if len(sitemaps) == 0 {
+		return fallback
+	}
+	return sitemaps
+}
+
+// fetch_sitemap reads a sitemap, gzipped or not
+func fetch_sitemap(url URL) (Sitemap_file, error) {
+	file := Sitemap_file{}
+	resp, err := fetch_client.Get(url)
+	if err != nil {
+		return file, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return file, fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	body := bufio.NewReader(io.LimitReader(resp.Body, MAX_SITEMAP_SIZE))
+	var reader io.Reader = body
+	// Gzip magic bytes, .gz sitemaps are usually served without Content-Encoding
+	if magic, err := body.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		unzipped, err := gzip.NewReader(body)
+		if err != nil {
+			return file, err
+		}
+		defer unzipped.Close()
+		reader = io.LimitReader(unzipped, MAX_SITEMAP_SIZE)
+	}
+
+	err = xml.NewDecoder(reader).Decode(&file)
+	return file, err
+}
+
+// record_sitemaps stores the pages listed in the sitemaps of the crawl's seed
+func record_sitemaps(index *Index, dg *dgo.Dgraph) {
+	listed := read_sitemaps(index.crawl.Seed)
+	index.sitemaps = listed
+	urls := []URL{}
+	for url := range listed {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+	Db_add_sitemap_pages(dg, urls, listed)
+	log.Info("Sitemaps recorded", "pages", len(urls))
+}
@@ -0,0 +1,92 @@
+package main
+
+import "strings"
+
+// stem reduces a word to a rough stem so "crawling" and "crawled" count as
+// the same term. These are light suffix strippers, not full stemmers
+func stem(word string, language string) string {
+	switch language {
+	case LANGUAGE_ENGLISH:
+		return stem_english(word)
+	case LANGUAGE_ARABIC:
+		return stem_arabic(word)
+	case LANGUAGE_FRENCH:
+		return strip_suffix(word, 3, "issements", "issement", "ements", "ement", "ations", "ation", "euses", "euse", "ités", "ité", "ives", "ive", "eux", "es", "s", "e")
+	case LANGUAGE_SPANISH:
+		return strip_suffix(word, 3, "aciones", "ación", "amientos", "amiento", "imientos", "imiento", "mente", "idades", "idad", "ando", "iendo", "es", "os", "as", "s", "o", "a")
+	case LANGUAGE_GERMAN:
+		return strip_suffix(word, 3, "heiten", "keiten", "heit", "keit", "lich", "isch", "ern", "em", "en", "er", "es", "e", "s")
+	}
+	return word
+}
+
+// strip_suffix removes the first matching suffix, keeping at least min_length runes
+func strip_suffix(word string, min_length int, suffixes ...string) string {
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(word, suffix) && len([]rune(word))-len([]rune(suffix)) >= min_length {
+			return strings.TrimSuffix(word, suffix)
+		}
+	}
+	return word
+}
+
+// Words the English rules would mangle, "news" isn't "new" + "s"
+var ENGLISH_STEM_EXCEPTIONS = map[string]bool{
+	"news": true, "series": true, "species": true, "business": true, "means": true, "lens": true,
+	"physics": true, "politics": true, "economics": true, "mathematics": true, "analytics": true,
+	"always": true, "perhaps": true, "nothing": true, "something": true, "anything": true,
+	"everything": true, "morning": true, "evening": true, "ceiling": true, "wedding": true,
+}
+
+func stem_english(word string) string {
+	if ENGLISH_STEM_EXCEPTIONS[word] || len(word) <= 3 {
+		return word
+	}
+
+	switch {
+	case strings.HasSuffix(word, "ies") && len(word) > 4:
+		word = strings.TrimSuffix(word, "ies") + "y"
+	case strings.HasSuffix(word, "sses"):
+		word = strings.TrimSuffix(word, "es")
+	case strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss") && !strings.HasSuffix(word, "us") && !strings.HasSuffix(word, "is"):
+		word = strings.TrimSuffix(word, "s")
+	}
+
+	// "organization" is "organize", not "organ"
+	for suffix, replacement := range map[string]string{"ization": "ize", "ational": "ate"} {
+		if strings.HasSuffix(word, suffix) && len(word)-len(suffix) >= 5 {
+			word = strings.TrimSuffix(word, suffix) + replacement
+		}
+	}
+
+	// Derivational suffixes need a longer stem, "document" isn't "docu" + "ment"
+	word = strip_suffix(word, 5, "fulness", "ousness", "iveness", "ments", "ment", "ingly", "edly", "ly")
+	word = strip_suffix(word, 4, "ness")
+
+	// "ing" and "ed" only go when a syllable is left, "string" isn't "str" + "ing"
+	for _, suffix := range []string{"ing", "ed"} {
+		stem := strings.TrimSuffix(word, suffix)
+		if stem == word || len(stem) < 3 || !strings.ContainsAny(stem, "aeiouy") || strings.HasSuffix(word, "eed") {
+			continue
+		}
+		word = stem
+		// "stopp" + "ed" leaves a double letter
+		if n := len(word); n > 3 && word[n-1] == word[n-2] && strings.ContainsRune("bdgmnprt", rune(word[n-1])) {
+			word = word[:n-1]
+		}
+		break
+	}
+	return word
+}
+
+// stem_arabic is a light stemmer: it drops common prefixes such as the
+// definite article and conjunctions, and common plural and pronoun suffixes
+func stem_arabic(word string) string {
+	for _, prefix := range []string{"وال", "بال", "كال", "فال", "لل", "ال"} {
+		if strings.HasPrefix(word, prefix) && len([]rune(word))-len([]rune(prefix)) >= 2 {
+			word = strings.TrimPrefix(word, prefix)
+			break
+		}
+	}
+	return strip_suffix(word, 2, "ها", "ان", "ات", "ون", "ين", "يه", "ية", "ه", "ة", "ي")
+}
@@ -0,0 +1,50 @@
+package main
+
+import "testing"
+
+func TestStem(t *testing.T) {
+	tests := []struct {
+		word     string
+		language string
+		want     string
+	}{
+		{"crawling", LANGUAGE_ENGLISH, "crawl"},
+		{"crawled", LANGUAGE_ENGLISH, "crawl"},
+		{"crawls", LANGUAGE_ENGLISH, "crawl"},
+		{"stopped", LANGUAGE_ENGLISH, "stop"},
+		{"running", LANGUAGE_ENGLISH, "run"},
+		{"studies", LANGUAGE_ENGLISH, "study"},
+		{"classes", LANGUAGE_ENGLISH, "class"},
+		{"darkness", LANGUAGE_ENGLISH, "dark"},
+		{"development", LANGUAGE_ENGLISH, "develop"},
+		{"quickly", LANGUAGE_ENGLISH, "quick"},
+		{"organization", LANGUAGE_ENGLISH, "organize"},
+		{"organizations", LANGUAGE_ENGLISH, "organize"},
+		{"organ", LANGUAGE_ENGLISH, "organ"},
+		// Words the rules must leave alone
+		{"news", LANGUAGE_ENGLISH, "news"},
+		{"series", LANGUAGE_ENGLISH, "series"},
+		{"business", LANGUAGE_ENGLISH, "business"},
+		{"string", LANGUAGE_ENGLISH, "string"},
+		{"spring", LANGUAGE_ENGLISH, "spring"},
+		{"speed", LANGUAGE_ENGLISH, "speed"},
+		{"status", LANGUAGE_ENGLISH, "status"},
+		{"analysis", LANGUAGE_ENGLISH, "analysis"},
+		{"document", LANGUAGE_ENGLISH, "document"},
+		{"family", LANGUAGE_ENGLISH, "family"},
+		{"the", LANGUAGE_ENGLISH, "the"},
+		{"الكتاب", LANGUAGE_ARABIC, "كتاب"},
+		{"والمدرسة", LANGUAGE_ARABIC, "مدرس"},
+		{"informations", LANGUAGE_FRENCH, "inform"},
+		{"ciudades", LANGUAGE_SPANISH, "ciudad"},
+		{"zeitungen", LANGUAGE_GERMAN, "zeitung"},
+		{"zeitung", LANGUAGE_GERMAN, "zeitung"},
+		{"zeiten", LANGUAGE_GERMAN, "zeit"},
+		{"crawling", "", "crawling"},
+	}
+	for _, test := range tests {
+		if got := stem(test.word, test.language); got != test.want {
+			t.Errorf("stem(%q, %q) = %q, want %q", test.word, test.language, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import "strings"
+
+// Languages with stopwords and a stemmer
+const LANGUAGE_ENGLISH = "en"
+const LANGUAGE_ARABIC = "ar"
+const LANGUAGE_FRENCH = "fr"
+const LANGUAGE_SPANISH = "es"
+const LANGUAGE_GERMAN = "de"
+
+var STOPWORDS_BY_LANGUAGE = map[string]map[string]bool{
+	LANGUAGE_ENGLISH: make_set(strings.Fields(`
+		a about above after again against all am an and any are as at be because been before being below between
+		both but by can could did do does doing down during each few for from further had has have having he her
+		here hers herself him himself his how i if in into is it its itself just me more most my myself no nor not
+		now of off on once only or other our ours ourselves out over own same she should so some such than that the
+		their theirs them themselves then there these they this those through to too under until up very was we were
+		what when where which while who whom why will with would you your yours yourself yourselves also may might
+		must shall us get got one two new like use used using via within without per etc
+	`)),
+	LANGUAGE_ARABIC: make_set(strings.Fields(`
+		في من على إلى الى عن مع هذا هذه ذلك تلك التي الذي الذين هو هي هم هن أنا نحن أنت أنتم كان كانت يكون
+		تكون قد لقد لا لم لن ما ماذا متى أين كيف كل بعض غير بين حتى إذا اذا إن ان أن أو او ثم بل لكن و ف ب ل
+		عند عليه عليها فيه فيها منه منها به بها له لها لهم كما أيضا ايضا أي اي هناك هنا بعد قبل حيث وهو وهي
+	`)),
+	LANGUAGE_FRENCH: make_set(strings.Fields(`
+		le la les un une des du de d l et ou mais donc or ni car à au aux en dans par pour sur sous avec sans ce
+		cet cette ces qui que quoi dont où il elle ils elles on nous vous je tu me te se lui leur leurs son sa ses
+		mon ma mes ton ta tes notre nos votre vos est sont été être avoir a ont as ai pas ne plus très tout tous
+		toute toutes comme si y c qu s n
+	`)),
+	LANGUAGE_SPANISH: make_set(strings.Fields(`
+		el la los las un una unos unas de del al y o u e pero sino ni que qué en con por para sin sobre entre
+		hasta desde este esta estos estas ese esa esos esas aquel aquella lo le les se me te nos os su sus mi mis
+		tu tus nuestro nuestra es son fue ser estar está están ha han hay no sí si más muy como cuando donde
+		quien cual también ya
+	`)),
+	LANGUAGE_GERMAN: make_set(strings.Fields(`
+		der die das den dem des ein eine einer eines einem einen und oder aber doch sondern denn in im an am auf
+		aus bei mit nach von vor zu zum zur über unter durch für ohne gegen um ich du er sie es wir ihr mich dich
+		sich uns euch mein dein sein unser euer ist sind war waren sein haben hat hatte wird werden wurde nicht
+		kein keine auch noch nur schon sehr so wie wenn als dass daß was wer wo
+	`)),
+}
+
+// guess_language picks the language whose stopwords show up most in the
+// tokens, english if none do
+func guess_language(tokens []string) string {
+	best_language, best_count := LANGUAGE_ENGLISH, 0
+	for _, language := range [...]string{LANGUAGE_ENGLISH, LANGUAGE_ARABIC, LANGUAGE_FRENCH, LANGUAGE_SPANISH, LANGUAGE_GERMAN} {
+		count := 0
+		for _, token := range tokens {
+			if STOPWORDS_BY_LANGUAGE[language][token] {
+				count += 1
+			}
+		}
+		if count > best_count {
+			best_language, best_count = language, count
+		}
+	}
+	return best_language
+}
+
+// is_stopword checks the stopwords of the language, falling back to english
+func is_stopword(token string, language string) bool {
+	stopwords, ok := STOPWORDS_BY_LANGUAGE[language]
+	if !ok {
+		stopwords = STOPWORDS_BY_LANGUAGE[LANGUAGE_ENGLISH]
+	}
+	return stopwords[token]
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// tokenize splits text into lowercase words, dropping punctuation and numbers
+func tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	})
+
+	tokens := []string{}
+	for _, word := range words {
+		word = strings.Trim(word, "'")
+		if len([]rune(word)) < 2 || is_number(word) {
+			continue
+		}
+		tokens = append(tokens, word)
+	}
+	return tokens
+}
+
+func is_number(word string) bool {
+	for _, r := range word {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// split_sentences breaks text on sentence punctuation and line breaks
+func split_sentences(text string) []string {
+	sentences := []string{}
+	start := 0
+	runes := []rune(text)
+	for i, r := range runes {
+		end := r == '\n' || ((r == '.' || r == '!' || r == '?' || r == '؟') && (i+1 == len(runes) || unicode.IsSpace(runes[i+1])))
+		if !end {
+			continue
+		}
+		if sentence := strings.TrimSpace(string(runes[start : i+1])); sentence != "" {
+			sentences = append(sentences, sentence)
+		}
+		start = i + 1
+	}
+	if sentence := strings.TrimSpace(string(runes[start:])); sentence != "" {
+		sentences = append(sentences, sentence)
+	}
+	return sentences
+}
+
+func make_set(words []string) map[string]bool {
+	set := make(map[string]bool)
+	for _, word := range words {
+		set[word] = true
+	}
+	return set
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+const ANALYZER_TFIDF = "tfidf" // Keywords scored against every page of the crawl
+
+// TF-IDF config
+const TFIDF_KEYWORD_COUNT = 10
+
+// Tfidf_analyzer keeps document frequencies across every page it has seen.
+// Pages are scored with the idf known so far when analyzed, and again with
+// the idf of the whole crawl once it ends
+type Tfidf_analyzer struct {
+	lock               sync.Mutex
+	document_frequency map[string]int
+	documents          map[URL]Term_counts
+	// The most common spelling of each stem, shown instead of the stem
+	spellings map[string]map[string]int
+}
+
+// Term_counts are the stemmed terms of a document and how often they occur
+type Term_counts struct {
+	counts map[string]int
+	total  int
+}
+
+func new_tfidf_analyzer() *Tfidf_analyzer {
+	return &Tfidf_analyzer{
+		document_frequency: make(map[string]int),
+		documents:          make(map[URL]Term_counts),
+		spellings:          make(map[string]map[string]int),
+	}
+}
+
+func (analyzer *Tfidf_analyzer) name() string {
+	return ANALYZER_TFIDF
+}
+
+func (analyzer *Tfidf_analyzer) version() string {
+	return "1"
+}
+
+func (analyzer *Tfidf_analyzer) analyze(document Document) (Annotations, error) {
+	tokens := tokenize(document.Text)
+	language := document_language(document, tokens)
+
+	terms := Term_counts{counts: make(map[string]int)}
+	analyzer.lock.Lock()
+	defer analyzer.lock.Unlock()
+	for _, token := range tokens {
+		if is_stopword(token, language) {
+			continue
+		}
+		term := stem(token, language)
+		terms.counts[term] += 1
+		terms.total += 1
+
+		if analyzer.spellings[term] == nil {
+			analyzer.spellings[term] = make(map[string]int)
+		}
+		analyzer.spellings[term][token] += 1
+	}
+
+	// A page seen again replaces its previous counts
+	if previous, ok := analyzer.documents[document.URL]; ok {
+		for term := range previous.counts {
+			analyzer.document_frequency[term] -= 1
+		}
+	}
+	for term := range terms.counts {
+		analyzer.document_frequency[term] += 1
+	}
+	analyzer.documents[document.URL] = terms
+
+	keywords, scores := analyzer.keywords(terms)
+	return Annotations{Keywords: keywords, Keyword_scores: scores, Topic_scores: scores}, nil
+}
+
+// rescore scores every document again with the final document frequencies
+func (analyzer *Tfidf_analyzer) rescore() map[URL]Annotations {
+	analyzer.lock.Lock()
+	defer analyzer.lock.Unlock()
+
+	annotations := make(map[URL]Annotations)
+	for url, terms := range analyzer.documents {
+		keywords, scores := analyzer.keywords(terms)
+		annotations[url] = Annotations{Keywords: keywords, Keyword_scores: scores, Topic_scores: scores}
+	}
+	return annotations
+}
+
+// keywords returns the best terms of a document by tf-idf, and their
+// scores. Each term is shown in its most common spelling across the crawl,
+// so it's the same topic on every page. The lock must be held
+func (analyzer *Tfidf_analyzer) keywords(terms Term_counts) ([]string, map[string]float64) {
+	scores := make(map[string]float64)
+	for term, count := range terms.counts {
+		scores[term] = analyzer.tfidf(count, terms.total, term)
+	}
+
+	ranked := []string{}
+	for term := range scores {
+		ranked = append(ranked, term)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if scores[ranked[i]] != scores[ranked[j]] {
+			return scores[ranked[i]] > scores[ranked[j]]
+		}
+		return ranked[i] < ranked[j]
+	})
+	if len(ranked) > TFIDF_KEYWORD_COUNT {
+		ranked = ranked[:TFIDF_KEYWORD_COUNT]
+	}
+
+	keywords := []string{}
+	keyword_scores := make(map[string]float64)
+	for _, term := range ranked {
+		spelling := analyzer.spelling(term)
+		keywords = append(keywords, spelling)
+		keyword_scores[spelling] = scores[term]
+	}
+	return keywords, keyword_scores
+}
+
+// tfidf uses the smoothed idf of scikit-learn, so terms found in every
+// document still count a little
+func (analyzer *Tfidf_analyzer) tfidf(count int, total int, term string) float64 {
+	idf := smoothed_idf(len(analyzer.documents), analyzer.document_frequency[term])
+	return float64(count) / float64(total) * idf
+}
+
+func smoothed_idf(document_count int, document_frequency int) float64 {
+	return math.Log(float64(1+document_count)/float64(1+document_frequency)) + 1
+}
+
+func (analyzer *Tfidf_analyzer) spelling(term string) string {
+	best, best_count := term, 0
+	for spelling, count := range analyzer.spellings[term] {
+		if count > best_count || (count == best_count && spelling < best) {
+			best, best_count = spelling, count
+		}
+	}
+	return best
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestTfidfAnalyzer(t *testing.T) {
+	analyzer := new_tfidf_analyzer()
+	documents := []Document{
+		{URL: "https://a.com/1", Text: "Crawling crawlers crawl the web. Crawling is fun.", Language: LANGUAGE_ENGLISH},
+		{URL: "https://a.com/2", Text: "The web is big and the crawler is small.", Language: LANGUAGE_ENGLISH},
+		{URL: "https://a.com/3", Text: "Gardening in the web of spring.", Language: LANGUAGE_ENGLISH},
+	}
+	for _, document := range documents {
+		if _, err := analyzer.analyze(document); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	annotations := analyzer.rescore()
+	first := annotations["https://a.com/1"]
+	// "crawling", "crawl" and "crawlers" share a stem shown in its most common spelling
+	if len(first.Keywords) == 0 || first.Keywords[0] != "crawling" {
+		t.Errorf("keywords %v, want crawling first", first.Keywords)
+	}
+	// "web" is on every page so it scores below terms of this page alone
+	if first.Keyword_scores["web"] >= first.Keyword_scores["fun"] {
+		t.Errorf("web scored %v, fun %v", first.Keyword_scores["web"], first.Keyword_scores["fun"])
+	}
+	if !reflect.DeepEqual(keys(first.Keyword_scores), sorted(first.Keywords)) {
+		t.Errorf("scores %v don't match keywords %v", first.Keyword_scores, first.Keywords)
+	}
+
+	// Analyzing a page again replaces its terms instead of counting them twice
+	analyzer.analyze(Document{URL: "https://a.com/3", Text: "Gardening in spring.", Language: LANGUAGE_ENGLISH})
+	if got := analyzer.document_frequency["web"]; got != 2 {
+		t.Errorf("web in %d documents, want 2", got)
+	}
+}
+
+func TestSmoothedIdf(t *testing.T) {
+	if got := smoothed_idf(3, 3); got != 1 {
+		t.Errorf("a term in every document has idf %v, want 1", got)
+	}
+	if smoothed_idf(3, 1) <= smoothed_idf(3, 2) {
+		t.Error("rarer terms should have a higher idf")
+	}
+}
+
+func keys(scores map[string]float64) []string {
+	result := []string{}
+	for key := range scores {
+		result = append(result, key)
+	}
+	return sorted(result)
+}
+
+func sorted(values []string) []string {
+	result := append([]string{}, values...)
+	sort.Strings(result)
+	return result
+}
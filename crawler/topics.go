@@ -0,0 +1,112 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/dgraph-io/dgo/v2"
+)
+
+// Topic graph config
+// Topics must show up together on this many pages to be linked
+const TOPIC_MIN_COOCCURRENCE = 2
+
+// Each topic keeps links to its most frequent neighbours only
+const TOPIC_MAX_NEIGHBOURS = 10
+
+// A Topic is a keyword stored once, linked from the pages it describes
+type Topic struct {
+	UID   string   `json:"uid,omitempty"`
+	Name  string   `json:"topic_name,omitempty"`
+	DType []string `json:"dgraph.type,omitempty"`
+
+	// Facet of the topics edge, the keyword's score on the page
+	Topic_score float64 `json:"topics|score,omitempty"`
+	// Facet of the co_occurs_with edge, pages having both topics
+	Cooccurrence_count int `json:"co_occurs_with|count,omitempty"`
+}
+
+// topics_from_annotations takes the topics and their weights from the
+// tfidf analyzer when it ran. Analyzers score keywords on scales of their
+// own and spell them their own way, mixing them would make the same topic
+// a different node and weight depending on which analyzer found it
+func topics_from_annotations(annotations Annotations) []Topic {
+	if len(annotations.Topic_scores) == 0 {
+		return topics_from_keywords(annotations.Keywords, annotations.Keyword_scores)
+	}
+	names := []string{}
+	for name := range annotations.Topic_scores {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if annotations.Topic_scores[names[i]] != annotations.Topic_scores[names[j]] {
+			return annotations.Topic_scores[names[i]] > annotations.Topic_scores[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	return topics_from_keywords(names, annotations.Topic_scores)
+}
+
+// topics_from_keywords names topics by their lowercase keyword, so "Go"
+// and "go" are the same topic
+func topics_from_keywords(keywords []string, scores map[string]float64) []Topic {
+	topics := []Topic{}
+	seen := make(map[string]bool)
+	for _, keyword := range keywords {
+		name := strings.ToLower(strings.TrimSpace(keyword))
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		topics = append(topics, Topic{Name: name, Topic_score: scores[keyword]})
+	}
+	return topics
+}
+
+// build_topic_graph links topics found together on the pages of this crawl
+// with co_occurs_with edges, replacing the edges those topics had
+func build_topic_graph(index *Index, dg *dgo.Dgraph) {
+	cooccurrences := make(map[string]map[string]int)
+	index.lock.Lock()
+	for url, page := range index.inprogress_or_done_pages {
+		// Aliases share their canonical page
+		if page.URL != url {
+			continue
+		}
+		for _, topic := range page.Topics {
+			for _, other := range page.Topics {
+				if topic.Name == other.Name {
+					continue
+				}
+				if cooccurrences[topic.Name] == nil {
+					cooccurrences[topic.Name] = make(map[string]int)
+				}
+				cooccurrences[topic.Name][other.Name] += 1
+			}
+		}
+	}
+	index.lock.Unlock()
+
+	edges := 0
+	for name, counts := range cooccurrences {
+		neighbours := []Topic{}
+		for other, count := range counts {
+			if count >= TOPIC_MIN_COOCCURRENCE {
+				neighbours = append(neighbours, Topic{Name: other, Cooccurrence_count: count})
+			}
+		}
+		sort.Slice(neighbours, func(i, j int) bool {
+			if neighbours[i].Cooccurrence_count != neighbours[j].Cooccurrence_count {
+				return neighbours[i].Cooccurrence_count > neighbours[j].Cooccurrence_count
+			}
+			return neighbours[i].Name < neighbours[j].Name
+		})
+		if len(neighbours) > TOPIC_MAX_NEIGHBOURS {
+			neighbours = neighbours[:TOPIC_MAX_NEIGHBOURS]
+		}
+		Db_set_cooccurrences(dg, name, neighbours)
+		edges += len(neighbours)
+	}
+	log.Info("Built the topic graph", "topics", len(cooccurrences), "edges", edges)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTopicsFromAnnotations(t *testing.T) {
+	// The Flask server scores keywords of the page alone, on another scale,
+	// and spells them as written
+	flask := Annotations{
+		Summary:        "A summary",
+		Keywords:       []string{"crawler", "crawled", "Web"},
+		Keyword_scores: map[string]float64{"crawler": 0.9, "crawled": 0.8, "Web": 0.7},
+	}
+	analyzer := new_tfidf_analyzer()
+	analyzer.analyze(Document{URL: "https://a.com/1", Text: "The crawler crawled the web, the crawler crawls.", Language: LANGUAGE_ENGLISH})
+	tfidf, _ := analyzer.analyze(Document{URL: "https://a.com/2", Text: "A crawler reads pages.", Language: LANGUAGE_ENGLISH})
+
+	annotations := Annotations{}
+	annotations.merge(flask)
+	annotations.merge(tfidf)
+	page := &Page{}
+	annotations.apply(page)
+
+	want := []Topic{}
+	for _, name := range []string{"pages", "reads", "crawler"} {
+		want = append(want, Topic{Name: name, Topic_score: tfidf.Keyword_scores[name]})
+	}
+	if !reflect.DeepEqual(page.Topics, want) {
+		t.Errorf("topics %+v, want tfidf's %+v", page.Topics, want)
+	}
+	if page.Summary != "A summary" || len(page.Keywords) != 5 {
+		t.Errorf("summary %q and %d keywords, want the flask summary and every keyword", page.Summary, len(page.Keywords))
+	}
+}
+
+func TestTopicsFromKeywords(t *testing.T) {
+	annotations := Annotations{}
+	annotations.merge(Annotations{Keywords: []string{"Go", "gopher"}, Keyword_scores: map[string]float64{"Go": 0.5}})
+	annotations.merge(Annotations{Keywords: []string{"go", "web"}, Keyword_scores: map[string]float64{"go": 2, "web": 1}})
+
+	want := []Topic{{Name: "go", Topic_score: 0.5}, {Name: "gopher"}, {Name: "web", Topic_score: 1}}
+	if got := topics_from_annotations(annotations); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}